@@ -0,0 +1,115 @@
+package godns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Kinds of the addresses which are not reachable from the internet
+const (
+	BogonCGNAT         = "CGNAT"
+	BogonPrivate       = "private"
+	BogonLoopback      = "loopback"
+	BogonLinkLocal     = "link-local"
+	BogonUniqueLocal   = "unique local"
+	BogonDocumentation = "documentation"
+	BogonBenchmarking  = "benchmarking"
+	BogonMulticast     = "multicast"
+	BogonUnspecified   = "unspecified"
+	BogonReserved      = "reserved"
+)
+
+// bogonNetworks are the networks of the addresses which shouldn't be published to public DNS
+var bogonNetworks = []struct {
+	network *net.IPNet
+	kind    string
+}{
+	{mustParseCIDR("0.0.0.0/8"), BogonUnspecified},
+	{mustParseCIDR("10.0.0.0/8"), BogonPrivate},
+	{mustParseCIDR("100.64.0.0/10"), BogonCGNAT},
+	{mustParseCIDR("127.0.0.0/8"), BogonLoopback},
+	{mustParseCIDR("169.254.0.0/16"), BogonLinkLocal},
+	{mustParseCIDR("172.16.0.0/12"), BogonPrivate},
+	{mustParseCIDR("192.0.0.0/24"), BogonReserved},
+	{mustParseCIDR("192.0.2.0/24"), BogonDocumentation},
+	{mustParseCIDR("192.168.0.0/16"), BogonPrivate},
+	{mustParseCIDR("198.18.0.0/15"), BogonBenchmarking},
+	{mustParseCIDR("198.51.100.0/24"), BogonDocumentation},
+	{mustParseCIDR("203.0.113.0/24"), BogonDocumentation},
+	{mustParseCIDR("224.0.0.0/4"), BogonMulticast},
+	{mustParseCIDR("240.0.0.0/4"), BogonReserved},
+	{mustParseCIDR("::/128"), BogonUnspecified},
+	{mustParseCIDR("::1/128"), BogonLoopback},
+	{mustParseCIDR("2001:db8::/32"), BogonDocumentation},
+	{mustParseCIDR("3fff::/20"), BogonDocumentation},
+	{mustParseCIDR("fc00::/7"), BogonUniqueLocal},
+	{mustParseCIDR("fe80::/10"), BogonLinkLocal},
+	{mustParseCIDR("ff00::/8"), BogonMulticast},
+}
+
+// globalUnicast is where all the public IPv6 addresses are allocated from
+var globalUnicast = mustParseCIDR("2000::/3")
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// BogonKind returns the kind of the address if it is not reachable from the internet,
+// like CGNAT or private, and an empty string for public addresses
+func BogonKind(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	for _, bogon := range bogonNetworks {
+		if bogon.network.Contains(addr) {
+			return bogon.kind
+		}
+	}
+	if addr.To4() == nil && !globalUnicast.Contains(addr) {
+		return BogonReserved
+	}
+	return ""
+}
+
+// allowBogon reports whether the sub domain of the domain may point to a non-public address
+func allowBogon(domain *Domain, subDomain string) bool {
+	for _, allowed := range domain.AllowPrivate {
+		if allowed == subDomain {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse logs and notifies that the records are not pointed to the non-public IP
+func refuse(configuration *Settings, ip string, names []string) {
+	sort.Strings(names)
+	kind := BogonKind(ip)
+
+	var message string
+	if kind == BogonCGNAT {
+		message = fmt.Sprintf("%s is a CGNAT address: your ISP puts you behind carrier-grade NAT, "+
+			"and the address can't be reached from the internet. ", ip)
+	} else {
+		message = fmt.Sprintf("%s is a %s address, which can't be reached from the internet. ", ip, kind)
+	}
+	message += fmt.Sprintf("It is not published to %s, add the sub domains to allow_private of the domain to publish it anyway.",
+		strings.Join(names, ", "))
+	log.Println(message)
+
+	if configuration.Notify.Enabled {
+		log.Print("Sending notification to:", configuration.Notify.SendTo)
+		if err := SendAlert(configuration, "GoDNS refused to publish "+ip, message); err != nil {
+			log.Println("Failed to send notification")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package godns
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBogonKind(t *testing.T) {
+	kinds := map[string]string{
+		"1.1.1.1":            "",
+		"100.64.1.1":         BogonCGNAT,
+		"100.128.0.1":        "",
+		"10.1.2.3":           BogonPrivate,
+		"172.31.255.255":     BogonPrivate,
+		"172.32.0.1":         "",
+		"192.168.1.1":        BogonPrivate,
+		"::ffff:192.168.1.1": BogonPrivate,
+		"127.0.0.1":          BogonLoopback,
+		"169.254.1.1":        BogonLinkLocal,
+		"203.0.113.5":        BogonDocumentation,
+		"255.255.255.255":    BogonReserved,
+		"2606:4700::1111":    "",
+		"::1":                BogonLoopback,
+		"fd00::1":            BogonUniqueLocal,
+		"fe80::1":            BogonLinkLocal,
+		"2001:db8::1":        BogonDocumentation,
+		"64:ff9b::1.1.1.1":   BogonReserved,
+	}
+	for ip, kind := range kinds {
+		if got := BogonKind(ip); got != kind {
+			t.Errorf("%s should be %q, got %q", ip, kind, got)
+		}
+	}
+}
+
+func TestSyncDomainRefusesBogons(t *testing.T) {
+	conf := &Settings{}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www", "lan"}, AllowPrivate: []string{"lan"}}
+	provider := &fakeProvider{updated: map[string]string{}}
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "100.64.1.1", nil, nil); err != nil {
+		t.Fatal("refused records should not fail the update:", err)
+	}
+	if _, ok := provider.updated["www"]; ok {
+		t.Error("www should not be updated to a CGNAT address")
+	}
+	if provider.updated["lan"] != "100.64.1.1" {
+		t.Error("lan allows private addresses, should be updated")
+	}
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "1.1.1.1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if provider.updated["www"] != "1.1.1.1" {
+		t.Error("www should be updated to the public address")
+	}
+}
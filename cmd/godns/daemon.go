@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TimothyYe/godns"
+	"github.com/TimothyYe/godns/handler"
+)
+
+const (
+	// shutdownTimeout is how long to wait for the domain loops to stop after a signal
+	shutdownTimeout = 10 * time.Second
+	// configCheckInterval is how often the config file is checked for changes
+	configCheckInterval = 10 * time.Second
+	// verifyTimeout is how long to wait for the provider to check the credentials
+	verifyTimeout = 30 * time.Second
+)
+
+// worker is a running domain worker, with the function to stop it
+type worker struct {
+	*godns.DomainWorker
+	cancel context.CancelFunc
+}
+
+// daemon runs one domain worker per domain and IP type, and applies the new
+// configuration to them on reload
+type daemon struct {
+	ctx        context.Context
+	supervisor *godns.Supervisor
+	detector   *godns.IPDetector
+	store      *godns.StateStore
+	handlers   map[string]handler.IHandler
+	workers    map[string]*worker
+}
+
+func newDaemon(ctx context.Context, conf *godns.Settings) *daemon {
+	// The state file is loaded once, it is not changed on reload
+	var store *godns.StateStore
+	if conf.StatePath != "" {
+		var err error
+		if store, err = godns.LoadState(conf.StatePath); err != nil {
+			log.Println("Failed to load state, starting with an empty one:", err.Error())
+			store = godns.NewStateStore(conf.StatePath)
+		}
+	}
+
+	return &daemon{
+		ctx:        ctx,
+		supervisor: godns.NewSupervisor(conf),
+		detector:   godns.NewIPDetector(conf),
+		store:      store,
+		handlers:   map[string]handler.IHandler{},
+		workers:    map[string]*worker{},
+	}
+}
+
+// run starts the domain workers and keeps them running until the context is done,
+// the configuration is reloaded on SIGHUP or when the config file changes
+func (d *daemon) run(conf *godns.Settings, reloadChan <-chan os.Signal) error {
+	for name, loop := range d.sharedLoops() {
+		d.supervisor.Go(d.ctx, name, loop)
+	}
+
+	d.apply(conf)
+
+	lastMod := configModTime()
+	configTicker := time.NewTicker(configCheckInterval)
+	defer configTicker.Stop()
+
+	var err error
+	for err == nil {
+		select {
+		case sig := <-reloadChan:
+			log.Printf("Got signal %v, reloading config...\n", sig)
+			lastMod = configModTime()
+			d.reload()
+		case <-configTicker.C:
+			if mod := configModTime(); !mod.Equal(lastMod) {
+				log.Println("Config file changed, reloading config...")
+				lastMod = mod
+				d.reload()
+			}
+		case <-d.ctx.Done():
+			err = d.ctx.Err()
+		}
+	}
+
+	// Wait for the in-flight updates to finish or to be abandoned
+	done := make(chan struct{})
+	go func() {
+		d.supervisor.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		return errors.New("timeout while waiting for domain loops to stop")
+	}
+
+	if err == context.Canceled {
+		// stopped by signal
+		return nil
+	}
+	return err
+}
+
+// sharedLoops returns the loops all the domains depend on, by name
+func (d *daemon) sharedLoops() map[string]func(ctx context.Context) {
+	return map[string]func(ctx context.Context){
+		// One detector finds the IP for all the domains
+		"detector": d.detector.Run,
+		// Address changes of ip_interface and default route changes are detected right away, without waiting for the interval
+		"watcher": d.detector.Watch,
+	}
+}
+
+// reload loads and applies the config file, an invalid config is rejected and the current one keeps running
+func (d *daemon) reload() {
+	var conf godns.Settings
+	if err := godns.LoadSettings(*optConf, &conf); err != nil {
+		log.Println("Failed to reload config, keep running with the current one:", err.Error())
+		return
+	}
+
+	if err := godns.CheckSettings(&conf); err != nil {
+		log.Println("Settings is invalid, keep running with the current one:", err.Error())
+		return
+	}
+
+	d.apply(&conf)
+	log.Println("Config reloaded")
+}
+
+// apply diffs the domains with the running workers: new workers are started, removed
+// ones are stopped, and the others get the new handler and settings in place
+func (d *daemon) apply(conf *godns.Settings) {
+	d.supervisor.SetConfiguration(conf)
+	d.detector.SetConfiguration(conf)
+
+	// a reload gives the failed shared loops a new restart budget too, no domain gets an IP without them
+	for name, loop := range d.sharedLoops() {
+		if d.supervisor.Failed(name) {
+			log.Println("Restarting failed", name)
+			d.supervisor.Go(d.ctx, name, loop)
+		}
+	}
+
+	workers := map[string]*worker{}
+	handlers := map[string]handler.IHandler{}
+	for i := range conf.Domains {
+		domain := &conf.Domains[i]
+		domainConf := godns.GetDomainSettings(conf, domain)
+
+		// One handler per account and IP type, so A and AAAA records are detected,
+		// cached and updated independently in dual-stack mode
+		for _, ipType := range godns.GetIPTypes(conf) {
+			domainTypeConf := godns.WithIPType(domainConf, ipType)
+
+			// handlers are reused as long as the settings they use are the same
+			key := handlerKey(domainTypeConf)
+			h, ok := handlers[key]
+			if !ok {
+				if h, ok = d.handlers[key]; !ok {
+					log.Printf("Creating %s DNS handler with provider: %s\n", ipType, domainTypeConf.Provider)
+					h = handler.CreateHandler(domainTypeConf.Provider)
+					h.SetConfiguration(domainTypeConf)
+					d.verify(h, domainTypeConf)
+				}
+				handlers[key] = h
+			}
+
+			workerKey := domain.DomainName + "/" + ipType
+			if w, ok := d.workers[workerKey]; ok {
+				w.Update(h, domainTypeConf, domain)
+				workers[workerKey] = w
+				// a reload gives a failed loop a new restart budget
+				if d.supervisor.Failed(workerKey) {
+					log.Printf("Restarting failed %s domain loop for: %s\n", ipType, domain.DomainName)
+					d.start(workerKey, w)
+				}
+				continue
+			}
+
+			log.Printf("Starting %s domain loop for: %s\n", ipType, domain.DomainName)
+			w := &worker{DomainWorker: godns.NewDomainWorker(h, domainTypeConf, domain, d.store)}
+			workers[workerKey] = w
+			d.start(workerKey, w)
+		}
+	}
+
+	for key, w := range d.workers {
+		if _, ok := workers[key]; !ok {
+			log.Println("Stopping domain loop for:", key)
+			w.cancel()
+		}
+	}
+
+	d.workers = workers
+	d.handlers = handlers
+}
+
+// verify checks the credentials of a new handler if its provider supports it, an invalid
+// one is only reported, the updates keep being retried in case it is fixed at the provider
+func (d *daemon) verify(h handler.IHandler, conf *godns.Settings) {
+	verifier, ok := h.(godns.Verifier)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, verifyTimeout)
+	defer cancel()
+	if err := verifier.Verify(ctx); err != nil {
+		log.Printf("Failed to verify the credentials of %s: %v\n", conf.Provider, err)
+	}
+}
+
+// start runs the worker under the supervisor, which restarts it when it panics
+func (d *daemon) start(key string, w *worker) {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	ctx, cancel := context.WithCancel(d.ctx)
+	w.cancel = cancel
+
+	d.supervisor.Go(ctx, key, func(ctx context.Context) {
+		w.Run(ctx, d.detector)
+	})
+}
+
+// configModTime returns the modification time of the config file, zero if it cannot be read
+func configModTime() time.Time {
+	info, err := os.Stat(*optConf)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// handlerKey identifies the account and IP type a handler works for, with all the settings it uses
+func handlerKey(conf *godns.Settings) string {
+	return strings.Join([]string{conf.Provider, conf.Email, conf.Password, conf.LoginToken, conf.Api,
+		conf.Socks5Proxy, conf.UserAgent, conf.IPType}, "|")
+}
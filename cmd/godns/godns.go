@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"log"
 
 	"github.com/TimothyYe/godns"
-	"github.com/TimothyYe/godns/handler"
 	"github.com/fatih/color"
 )
 
@@ -42,29 +44,29 @@ func main() {
 
 	// Init log settings
 	log.SetPrefix("[GoDNS] ")
-	log.Println("GoDNS started, entering main loop...")
-	dnsLoop()
-}
 
-func dnsLoop() {
-	panicChan := make(chan godns.Domain)
+	// Cancel the context on SIGINT/SIGTERM, all the loops and HTTP requests stop with it
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		log.Printf("Got signal %v, shutting down...\n", sig)
+		cancel()
 
-	log.Println("Creating DNS handler with provider:", configuration.Provider)
-	handler := handler.CreateHandler(configuration.Provider)
-	handler.SetConfiguration(&configuration)
-	for i := range configuration.Domains {
-		go handler.DomainLoop(&configuration.Domains[i], panicChan)
-	}
+		sig = <-sigChan
+		log.Printf("Got signal %v again, exit now\n", sig)
+		os.Exit(1)
+	}()
 
-	panicCount := 0
-	for {
-		failDomain := <-panicChan
-		log.Println("Got panic in goroutine, will start a new one... :", panicCount)
-		go handler.DomainLoop(&failDomain, panicChan)
+	// Reload the config on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
-		panicCount++
-		if panicCount >= godns.PanicMax {
-			os.Exit(1)
-		}
+	log.Println("GoDNS started, entering main loop...")
+	if err := newDaemon(ctx, &configuration).run(&configuration, reloadChan); err != nil {
+		log.Println("GoDNS stopped with error:", err.Error())
+		os.Exit(1)
 	}
+	log.Println("GoDNS stopped")
 }
@@ -0,0 +1,73 @@
+package godns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// SourceCommand gets the IP from the output of a command
+	SourceCommand = "command"
+	// SourceFile gets the IP from a file, e.g. written by a dhclient or pppd hook
+	SourceFile = "file"
+)
+
+// GetIPFromCommand runs the command of the source within its timeout, and gets the IP from
+// its stdout, following the format of the source. A non-zero exit code is an error.
+func GetIPFromCommand(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	if len(source.Command) == 0 {
+		return "", errors.New("command cannot be empty")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, source.Command[0], source.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("command timed out: %v", ctx.Err())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("command failed: %v: %s", err, msg)
+		}
+		return "", fmt.Errorf("command failed: %v", err)
+	}
+
+	body := stdout.Bytes()
+	if len(body) > maxResponseSize {
+		body = body[:maxResponseSize]
+	}
+	ip, err := ParseIPResponse(source, body)
+	if err != nil {
+		return "", err
+	}
+	return ValidateIP(configuration, ip)
+}
+
+// GetIPFromFile reads the IP from the file of the source, following the format of the source
+func GetIPFromFile(configuration *Settings, source *IPSource) (string, error) {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(file, maxResponseSize))
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := ParseIPResponse(source, body)
+	if err != nil {
+		return "", err
+	}
+	return ValidateIP(configuration, ip)
+}
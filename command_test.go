@@ -0,0 +1,77 @@
+package godns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetIPFromCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell to run commands")
+	}
+
+	tests := []struct {
+		source IPSource
+		ip     string
+	}{
+		{IPSource{Command: []string{"echo", "1.1.1.1"}}, "1.1.1.1"},
+		{IPSource{Command: []string{"sh", "-c", `echo '{"wan": {"ip": "2.2.2.2"}}'`}, Format: "json", Field: "wan.ip"}, "2.2.2.2"},
+		{IPSource{Command: []string{"sh", "-c", "echo IP-MIB::ipAdEntAddr.3.3.3.3 = IpAddress: 3.3.3.3"},
+			Format: "regex", Regex: `IpAddress: (\S+)`}, "3.3.3.3"},
+	}
+	for _, test := range tests {
+		test.source.Type = SourceCommand
+		if ip, err := GetIPFromSource(context.Background(), &Settings{}, &test.source); err != nil || ip != test.ip {
+			t.Errorf("should get %s from %v, got %s, %v", test.ip, test.source.Command, ip, err)
+		}
+	}
+
+	failures := []IPSource{
+		{Command: []string{"sh", "-c", "echo 1.1.1.1; exit 1"}},
+		{Command: []string{"echo", "not an ip"}},
+		{Command: []string{"echo", "2001:db8::1"}},
+		{Command: []string{"no-such-command"}},
+	}
+	for _, source := range failures {
+		source.Type = SourceCommand
+		if ip, err := GetIPFromSource(context.Background(), &Settings{}, &source); err == nil {
+			t.Errorf("%v should fail, got %s", source.Command, ip)
+		}
+	}
+
+	start := time.Now()
+	source := &IPSource{Type: SourceCommand, Command: []string{"sleep", "5"}, Timeout: 1}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err == nil {
+		t.Errorf("slow command should time out, got %s", ip)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("command should be killed after 1 second, took %v", elapsed)
+	}
+}
+
+func TestGetIPFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wan")
+	if err := ioutil.WriteFile(path, []byte("new_ip_address=1.1.1.1\nnew_subnet_mask=255.255.255.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &IPSource{Type: SourceFile, Path: path, Format: "trace", Field: "new_ip_address"}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "1.1.1.1" {
+		t.Errorf("should get the IP from the file, got %s, %v", ip, err)
+	}
+
+	source = &IPSource{Type: SourceFile, Path: filepath.Join(dir, "missing")}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err == nil {
+		t.Errorf("missing file should fail, got %s", ip)
+	}
+}
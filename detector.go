@@ -0,0 +1,222 @@
+package godns
+
+import (
+	"context"
+	"log"
+	"path"
+	"sync"
+	"time"
+)
+
+// Addresses maps the IP types (IPv4, IPv6) to the detected IP addresses
+type Addresses map[string]string
+
+// IPDetector detects the current IP addresses once per interval, and broadcasts
+// the changes to all the subscribed domain loops
+type IPDetector struct {
+	mu            sync.Mutex
+	configuration *Settings
+	addresses     Addresses
+	subscribers   map[chan Addresses]struct{}
+	// pending are the changed addresses not stable yet
+	pending map[string]*candidate
+
+	// trigger wakes up the detector before the interval is over
+	trigger chan struct{}
+}
+
+// NewIPDetector creates an IP detector for all the IP types in configuration
+func NewIPDetector(configuration *Settings) *IPDetector {
+	return &IPDetector{
+		configuration: configuration,
+		addresses:     Addresses{},
+		subscribers:   map[chan Addresses]struct{}{},
+		pending:       map[string]*candidate{},
+		trigger:       make(chan struct{}, 1),
+	}
+}
+
+// SetConfiguration replaces the settings of a running detector, and detects the IP again
+func (d *IPDetector) SetConfiguration(configuration *Settings) {
+	d.mu.Lock()
+	changed := d.configuration != configuration
+	d.configuration = configuration
+	d.mu.Unlock()
+
+	if changed {
+		d.Trigger()
+	}
+}
+
+// Trigger asks the detector to detect the IP right away
+func (d *IPDetector) Trigger() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// getConfiguration returns the settings in use
+func (d *IPDetector) getConfiguration() *Settings {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.configuration
+}
+
+// Subscribe returns a channel which receives the addresses whenever any of them changes.
+// The known addresses are sent right away, and a slow subscriber only gets the latest ones.
+func (d *IPDetector) Subscribe() <-chan Addresses {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan Addresses, 1)
+	d.subscribers[ch] = struct{}{}
+	if len(d.addresses) > 0 {
+		ch <- d.snapshot()
+	}
+	return ch
+}
+
+// Unsubscribe stops sending addresses to the channel
+func (d *IPDetector) Unsubscribe(ch <-chan Addresses) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for sub := range d.subscribers {
+		if sub == ch {
+			delete(d.subscribers, sub)
+		}
+	}
+}
+
+// Run detects the addresses every interval until the context is done, failed
+// detections are retried sooner following the retry policy
+func (d *IPDetector) Run(ctx context.Context) {
+	backoff := NewBackoff(d.getConfiguration().Retry.Detect)
+	poll := true
+	for {
+		ok := d.detect(ctx, poll)
+
+		// Sleep with interval
+		configuration := d.getConfiguration()
+		delay := time.Second * time.Duration(configuration.Interval)
+		if ok {
+			backoff.Reset()
+		} else if retry := backoff.Next(); retry < delay {
+			delay = retry
+		}
+		if wait := d.holdDownWait(configuration.HoldDown, time.Now()); wait > 0 && wait < delay {
+			delay = wait
+		}
+
+		log.Printf("Going to sleep, will start next checking in %.0f seconds...\r\n", delay.Seconds())
+		select {
+		case <-time.After(delay):
+			poll = true
+		case <-d.trigger:
+			poll = false
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Watch detects the IP right away when an address of a watched interface or the default route
+// changes, until the context is done. Polling every interval keeps running as a safety net.
+// Only Linux is supported.
+func (d *IPDetector) Watch(ctx context.Context) {
+	err := watchAddresses(ctx, func(name string) {
+		if isWatched(d.getConfiguration(), name) {
+			log.Printf("Address of %s changed, checking the IP...\n", name)
+			d.Trigger()
+		}
+	}, func(reason string) {
+		log.Printf("%s, checking the IP...\n", reason)
+		d.Trigger()
+	})
+
+	if err != nil && ctx.Err() == nil {
+		log.Println("Failed to watch address and route changes, fallback to polling:", err)
+	}
+}
+
+// isWatched reports whether the IP is got from the interface
+func isWatched(configuration *Settings, name string) bool {
+	for pattern := range watchedInterfaces(configuration) {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedInterfaces returns the interface names and globs the IP is got from, for all the IP types
+func watchedInterfaces(configuration *Settings) map[string]bool {
+	interfaces := map[string]bool{}
+	for _, ipType := range GetIPTypes(configuration) {
+		sources := GetIPSources(WithIPType(configuration, ipType))
+		for i := range sources {
+			if sourceType(&sources[i]) == SourceInterface {
+				interfaces[sources[i].Interface] = true
+			}
+		}
+	}
+	return interfaces
+}
+
+// Detect gets the current IP of each IP type once, and broadcasts them if any of them changed
+// and is stable as the hold-down policy. The last known address of an IP type is kept if it fails
+// to get the current one, false is returned if any of them failed.
+func (d *IPDetector) Detect(ctx context.Context) bool {
+	return d.detect(ctx, true)
+}
+
+// detect gets the current IPs, poll is set if it is a scheduled check rather than a triggered one
+func (d *IPDetector) detect(ctx context.Context, poll bool) bool {
+	configuration := d.getConfiguration()
+	changed, ok := false, true
+	for _, ipType := range GetIPTypes(configuration) {
+		currentIP, err := GetCurrentIP(ctx, WithIPType(configuration, ipType))
+		if err != nil || currentIP == "" {
+			log.Printf("[%s] Failed to get current IP: %v\n", ipType, err)
+			ok = false
+			continue
+		}
+
+		d.mu.Lock()
+		if d.holdDown(configuration.HoldDown, ipType, currentIP, time.Now(), poll) {
+			log.Printf("[%s] Current IP is: %s\n", ipType, currentIP)
+			d.addresses[ipType] = currentIP
+			changed = true
+		}
+		d.mu.Unlock()
+	}
+
+	if changed {
+		d.broadcast()
+	}
+	return ok
+}
+
+// broadcast sends the addresses to all the subscribers, replacing the stale ones not received yet
+func (d *IPDetector) broadcast() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for ch := range d.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- d.snapshot()
+	}
+}
+
+// snapshot copies the addresses, the caller must hold the lock
+func (d *IPDetector) snapshot() Addresses {
+	addresses := make(Addresses, len(d.addresses))
+	for ipType, ip := range d.addresses {
+		addresses[ipType] = ip
+	}
+	return addresses
+}
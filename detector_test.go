@@ -0,0 +1,82 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPDetector(t *testing.T) {
+	ip := "1.1.1.1"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, ip)
+	}))
+	defer server.Close()
+
+	detector := NewIPDetector(&Settings{IPUrl: server.URL, Interval: 300})
+	first := detector.Subscribe()
+	second := detector.Subscribe()
+
+	detector.Detect(context.Background())
+	if requests != 1 {
+		t.Errorf("IP should be detected once for all the subscribers, got %d requests", requests)
+	}
+	for _, sub := range []<-chan Addresses{first, second} {
+		if addresses := <-sub; addresses[IPV4] != "1.1.1.1" {
+			t.Errorf("subscriber should get the detected IP, got %v", addresses)
+		}
+	}
+
+	// unchanged IP is not broadcasted
+	detector.Detect(context.Background())
+	select {
+	case addresses := <-first:
+		t.Errorf("unchanged IP should not be broadcasted, got %v", addresses)
+	default:
+	}
+
+	// a slow subscriber only gets the latest IP
+	ip = "2.2.2.2"
+	detector.Detect(context.Background())
+	ip = "3.3.3.3"
+	detector.Detect(context.Background())
+	if addresses := <-first; addresses[IPV4] != "3.3.3.3" {
+		t.Errorf("subscriber should get the latest IP, got %v", addresses)
+	}
+
+	// new subscriber gets the known IP right away
+	late := detector.Subscribe()
+	if addresses := <-late; addresses[IPV4] != "3.3.3.3" {
+		t.Errorf("new subscriber should get the known IP, got %v", addresses)
+	}
+
+	detector.Unsubscribe(second)
+	<-second
+	ip = "4.4.4.4"
+	detector.Detect(context.Background())
+	select {
+	case addresses := <-second:
+		t.Errorf("unsubscribed channel should not get IP, got %v", addresses)
+	default:
+	}
+}
+
+func TestWatchedInterfaces(t *testing.T) {
+	conf := &Settings{IPType: DUALSTACK, IPInterface: "ppp0"}
+	if interfaces := watchedInterfaces(conf); len(interfaces) != 1 || !interfaces["ppp0"] {
+		t.Errorf("ip_interface should be watched, got %v", interfaces)
+	}
+
+	conf.IPSources = []IPSource{
+		{Type: SourceInterface, Interface: "eth0", IPType: IPV4},
+		{Type: SourceInterface, Interface: "eth1", IPType: IPV6},
+		{URL: "http://ip.example.com"},
+	}
+	if interfaces := watchedInterfaces(conf); len(interfaces) != 2 || !interfaces["eth0"] || !interfaces["eth1"] {
+		t.Errorf("interface sources should be watched, got %v", interfaces)
+	}
+}
@@ -0,0 +1,158 @@
+package godns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// newEchoServer serves the address of the client on the loopback addresses of both IP types,
+// at the same port
+func newEchoServer(t *testing.T) (int, func()) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprintln(w, host)
+	})
+
+	l4, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l4.Addr().(*net.TCPAddr).Port
+	go http.Serve(l4, handler)
+
+	l6, err := net.Listen("tcp6", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		l4.Close()
+		t.Skip("can't listen on IPv6 loopback:", err)
+	}
+	go http.Serve(l6, handler)
+
+	return port, func() {
+		l4.Close()
+		l6.Close()
+	}
+}
+
+// newSOCKS5Server is a SOCKS5 proxy without auth, which records the address type of the CONNECT requests
+func newSOCKS5Server(t *testing.T, atyps chan<- byte) net.Listener {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				// greeting: version, methods
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+					return
+				}
+				conn.Write([]byte{5, 0})
+
+				// request: version, CONNECT, reserved, address type, address, port
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+					return
+				}
+				atyp := buf[3]
+				atyps <- atyp
+				var host string
+				switch atyp {
+				case 1, 4:
+					ip := make(net.IP, map[byte]int{1: 4, 4: 16}[atyp])
+					if _, err := io.ReadFull(conn, ip); err != nil {
+						return
+					}
+					host = ip.String()
+				case 3:
+					if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+						return
+					}
+					name := make([]byte, buf[0])
+					if _, err := io.ReadFull(conn, name); err != nil {
+						return
+					}
+					host = string(name)
+				}
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				port := binary.BigEndian.Uint16(buf)
+
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+func TestGetIPOnlinePinned(t *testing.T) {
+	port, stop := newEchoServer(t)
+	defer stop()
+
+	atyps := make(chan byte, 10)
+	socks := newSOCKS5Server(t, atyps)
+	defer socks.Close()
+
+	// the IPv4 loopback can't be reached over tcp6, directly or via the proxy
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	for _, proxy := range []string{"", socks.Addr().String()} {
+		conf := &Settings{IPUrl: url, IPV6Url: url, Socks5Proxy: proxy}
+		if ip, err := GetIPOnline(context.Background(), conf); err != nil || ip != "127.0.0.1" {
+			t.Errorf("IPv4 should be detected over tcp4 (proxy %q), got %s, %v", proxy, ip, err)
+		}
+		if ip, err := GetIPOnline(context.Background(), WithIPType(conf, IPV6)); err == nil {
+			t.Errorf("IPv6 shouldn't be detected over tcp4 (proxy %q), got %s", proxy, ip)
+		}
+	}
+	if atyp := <-atyps; atyp != 1 {
+		t.Errorf("proxy should get an IPv4 address, got address type %d", atyp)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), "localhost")
+	if err != nil || len(addrs) < 2 {
+		t.Skip("localhost doesn't resolve to both IP types")
+	}
+
+	// both IP types of the same host name, the proxy gets the IP rather than the name
+	url = fmt.Sprintf("http://localhost:%d", port)
+	for _, proxy := range []string{"", socks.Addr().String()} {
+		conf := &Settings{IPUrl: url, IPV6Url: url, Socks5Proxy: proxy}
+		if ip, err := GetIPOnline(context.Background(), conf); err != nil || ip != "127.0.0.1" {
+			t.Errorf("IPv4 should be detected over tcp4 (proxy %q), got %s, %v", proxy, ip, err)
+		}
+		if ip, err := GetIPOnline(context.Background(), WithIPType(conf, IPV6)); err != nil || ip != "::1" {
+			t.Errorf("IPv6 should be detected over tcp6 (proxy %q), got %s, %v", proxy, ip, err)
+		}
+	}
+	for _, want := range []byte{1, 4} {
+		if atyp := <-atyps; atyp != want {
+			t.Errorf("proxy should get an IP address of type %d, got address type %d", want, atyp)
+		}
+	}
+}
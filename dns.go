@@ -0,0 +1,164 @@
+package godns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// SourceDNS gets the IP from a resolver which answers with the address of the client
+	SourceDNS = "dns"
+	// DefaultDNSResolver is the resolver of the dns source
+	DefaultDNSResolver = "resolver1.opendns.com"
+	// DefaultDNSQuery is the query name of the dns source
+	DefaultDNSQuery = "myip.opendns.com"
+	// dnsRetransmit is the initial retransmission timeout
+	dnsRetransmit = time.Second
+)
+
+// GetIPFromDNS asks the resolver of the source for the query name, like
+// `dig myip.opendns.com @resolver1.opendns.com`, or `dig TXT o-o.myaddr.l.google.com @ns1.google.com`.
+// The query is sent over IPv4 or IPv6 as configured, so the resolver sees the address of that IP type,
+// and sent again if it or the response is lost.
+func GetIPFromDNS(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	resolver := source.Resolver
+	if resolver == "" {
+		resolver = DefaultDNSResolver
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+
+	query := source.Query
+	if query == "" {
+		query = DefaultDNSQuery
+	}
+	if !strings.HasSuffix(query, ".") {
+		query += "."
+	}
+	name, err := dnsmessage.NewName(query)
+	if err != nil {
+		return "", err
+	}
+
+	queryType, err := dnsQueryType(configuration, source)
+	if err != nil {
+		return "", err
+	}
+
+	network := "udp4"
+	if IsIPv6(configuration) {
+		network = "udp6"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, resolver)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(1 << 16))
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: name, Type: queryType, Class: dnsmessage.ClassINET})
+	msg, err := builder.Finish()
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := exchangeUDP(ctx, conn, msg, dnsRetransmit, func(response []byte) (net.IP, error) {
+		ip, err := parseDNSResponse(configuration, response, id)
+		return net.ParseIP(ip), err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// parseDNSResponse gets the first valid IP from the A, AAAA or TXT answers of the response
+func parseDNSResponse(configuration *Settings, msg []byte, id uint16) (string, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(msg)
+	if err != nil {
+		return "", err
+	}
+	if header.ID != id || !header.Response {
+		return "", errUnexpectedResponse
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return "", fmt.Errorf("DNS query failed: %v", header.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return "", err
+	}
+
+	var values []string
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch answer.Type {
+		case dnsmessage.TypeA:
+			r, err := parser.AResource()
+			if err != nil {
+				return "", err
+			}
+			values = append(values, net.IP(r.A[:]).String())
+		case dnsmessage.TypeAAAA:
+			r, err := parser.AAAAResource()
+			if err != nil {
+				return "", err
+			}
+			values = append(values, net.IP(r.AAAA[:]).String())
+		case dnsmessage.TypeTXT:
+			r, err := parser.TXTResource()
+			if err != nil {
+				return "", err
+			}
+			values = append(values, r.TXT...)
+		default:
+			if err := parser.SkipAnswer(); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// a TXT answer may come with other strings, e.g. the EDNS client subnet
+	for _, value := range values {
+		if ip, err := ValidateIP(configuration, value); err == nil {
+			return ip, nil
+		}
+	}
+	return "", errors.New("no valid IP in DNS response")
+}
+
+// dnsQueryType returns the query type of the source, A or AAAA for the IP type if not set
+func dnsQueryType(configuration *Settings, source *IPSource) (dnsmessage.Type, error) {
+	switch strings.ToUpper(source.QueryType) {
+	case "":
+		if IsIPv6(configuration) {
+			return dnsmessage.TypeAAAA, nil
+		}
+		return dnsmessage.TypeA, nil
+	case "A":
+		return dnsmessage.TypeA, nil
+	case "AAAA":
+		return dnsmessage.TypeAAAA, nil
+	case "TXT":
+		return dnsmessage.TypeTXT, nil
+	}
+	return 0, fmt.Errorf("unknown query type: %s", source.QueryType)
+}
@@ -0,0 +1,118 @@
+package godns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDNSServer answers the queries of the name with the address of the client,
+// and the TXT queries with the EDNS client subnet string too. The first drop queries are lost.
+func newDNSServer(t *testing.T, network, address, name string, drop int) net.PacketConn {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skip("can't listen on", address, err)
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+
+			header.Response = true
+			if question.Name.String() != name {
+				header.RCode = dnsmessage.RCodeNameError
+			}
+			builder := dnsmessage.NewBuilder(nil, header)
+			builder.StartQuestions()
+			builder.Question(question)
+			builder.StartAnswers()
+
+			ip := addr.(*net.UDPAddr).IP
+			rh := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET}
+			switch {
+			case header.RCode != dnsmessage.RCodeSuccess:
+			case question.Type == dnsmessage.TypeA && ip.To4() != nil:
+				var a [4]byte
+				copy(a[:], ip.To4())
+				builder.AResource(rh, dnsmessage.AResource{A: a})
+			case question.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+				var aaaa [16]byte
+				copy(aaaa[:], ip)
+				builder.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: aaaa})
+			case question.Type == dnsmessage.TypeTXT:
+				builder.TXTResource(rh, dnsmessage.TXTResource{TXT: []string{"edns0-client-subnet 1.2.3.0/24"}})
+				builder.TXTResource(rh, dnsmessage.TXTResource{TXT: []string{ip.String()}})
+			}
+
+			msg, err := builder.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(msg, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestGetIPFromDNS(t *testing.T) {
+	server := newDNSServer(t, "udp4", "127.0.0.1:0", "myip.example.com.", 0)
+	defer server.Close()
+
+	conf := &Settings{}
+	source := &IPSource{Type: SourceDNS, Resolver: server.LocalAddr().String(), Query: "myip.example.com", Timeout: 2}
+	if ip, err := GetIPFromSource(context.Background(), conf, source); err != nil || ip != "127.0.0.1" {
+		t.Errorf("should get the IP from the A record, got %s, %v", ip, err)
+	}
+
+	source.QueryType = "txt"
+	if ip, err := GetIPFromSource(context.Background(), conf, source); err != nil || ip != "127.0.0.1" {
+		t.Errorf("should get the IP from the TXT record, got %s, %v", ip, err)
+	}
+
+	source.Query = "unknown.example.com"
+	if ip, err := GetIPFromSource(context.Background(), conf, source); err == nil {
+		t.Errorf("should fail on unknown name, got %s", ip)
+	}
+}
+
+func TestGetIPFromDNSIPv6(t *testing.T) {
+	server := newDNSServer(t, "udp6", "[::1]:0", "myip.example.com.", 0)
+	defer server.Close()
+
+	conf := &Settings{IPType: IPV6}
+	source := &IPSource{Type: SourceDNS, Resolver: server.LocalAddr().String(), Query: "myip.example.com", Timeout: 2}
+	if ip, err := GetIPFromSource(context.Background(), conf, source); err != nil || ip != "::1" {
+		t.Errorf("should get the IP from the AAAA record, got %s, %v", ip, err)
+	}
+}
+
+func TestGetIPFromDNSRetransmit(t *testing.T) {
+	server := newDNSServer(t, "udp4", "127.0.0.1:0", "myip.example.com.", 1)
+	defer server.Close()
+
+	source := &IPSource{Type: SourceDNS, Resolver: server.LocalAddr().String(), Query: "myip.example.com", Timeout: 3}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "127.0.0.1" {
+		t.Errorf("should send the lost query again, got %s, %v", ip, err)
+	}
+}
@@ -0,0 +1,445 @@
+package godns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// SourceGateway gets the IP from the external address of the gateway
+	SourceGateway = "gateway"
+	// ProtocolUPnP asks the gateway with UPnP IGD GetExternalIPAddress
+	ProtocolUPnP = "upnp"
+	// ProtocolNATPMP asks the gateway with NAT-PMP
+	ProtocolNATPMP = "natpmp"
+	// ProtocolPCP asks the gateway with PCP
+	ProtocolPCP = "pcp"
+
+	natpmpPort = 5351
+	ssdpAddr   = "239.255.255.250:1900"
+	// gatewayRetransmit is the initial retransmission timeout of NAT-PMP and PCP
+	gatewayRetransmit = 250 * time.Millisecond
+	// ssdpTimeout is how long to wait for the gateway to answer SSDP, a bit longer than its MX
+	ssdpTimeout = 3 * time.Second
+
+	pcpVersion       = 2
+	pcpOpcodeMap     = 1
+	pcpResultSuccess = 0
+	pcpProtocolUDP   = 17
+	pcpHeaderSize    = 24
+	pcpMapSize       = 36
+)
+
+// upnpServices are the IGD services which provide GetExternalIPAddress
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// GetIPFromGateway asks the gateway for its external address with the protocols of the source
+// in order, UPnP IGD, NAT-PMP and then PCP by default
+func GetIPFromGateway(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	protocols := source.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{ProtocolUPnP, ProtocolNATPMP, ProtocolPCP}
+	}
+
+	var errs []string
+	for i, protocol := range protocols {
+		// each protocol gets its share of the time left, so that a gateway which doesn't
+		// answer one of them leaves time for the next ones
+		protocolCtx, cancel := shareDeadline(ctx, len(protocols)-i)
+		var ip net.IP
+		var err error
+		switch strings.ToLower(protocol) {
+		case ProtocolUPnP:
+			ip, err = getIPFromUPnP(protocolCtx, configuration, source)
+		case ProtocolNATPMP:
+			ip, err = getIPFromNATPMP(protocolCtx, configuration, source)
+		case ProtocolPCP:
+			ip, err = getIPFromPCP(protocolCtx, configuration, source)
+		default:
+			err = fmt.Errorf("unknown gateway protocol: %s", protocol)
+		}
+		cancel()
+
+		if err == nil {
+			return ValidateIP(configuration, ip.String())
+		}
+		errs = append(errs, protocol+": "+err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+// shareDeadline returns a context with the n-th part of the time left before the deadline of ctx
+func shareDeadline(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(n))
+}
+
+// gatewayAddress returns the address of the gateway with the port, the one of the source
+// or the default gateway of the system
+func gatewayAddress(configuration *Settings, source *IPSource, port int) (string, error) {
+	gateway := source.Gateway
+	if gateway == "" {
+		ip, err := defaultGateway(IsIPv6(configuration))
+		if err != nil {
+			return "", err
+		}
+		gateway = ip.String()
+	}
+
+	if _, _, err := net.SplitHostPort(gateway); err == nil {
+		return gateway, nil
+	}
+	return net.JoinHostPort(gateway, fmt.Sprint(port)), nil
+}
+
+// defaultGateway finds the default gateway in the routing table, only Linux is supported
+func defaultGateway(ipv6 bool) (net.IP, error) {
+	if ipv6 {
+		// dest dest_len src src_len next_hop metric ref use flags iface, in hex
+		data, err := ioutil.ReadFile("/proc/net/ipv6_route")
+		if err != nil {
+			return nil, errors.New("gateway cannot be found, please set it in the ip source")
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[0] != strings.Repeat("0", 32) || fields[1] != "00" {
+				continue
+			}
+			if ip, err := hex.DecodeString(fields[4]); err == nil && !net.IP(ip).IsUnspecified() {
+				return net.IP(ip), nil
+			}
+		}
+	} else {
+		// iface destination gateway flags ..., addresses in little endian hex
+		data, err := ioutil.ReadFile("/proc/net/route")
+		if err != nil {
+			return nil, errors.New("gateway cannot be found, please set it in the ip source")
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			if ip, err := hex.DecodeString(fields[2]); err == nil && len(ip) == net.IPv4len {
+				return net.IPv4(ip[3], ip[2], ip[1], ip[0]), nil
+			}
+		}
+	}
+	return nil, errors.New("no default gateway, please set it in the ip source")
+}
+
+// getIPFromNATPMP sends a NAT-PMP (RFC 6886) external address request to the gateway
+func getIPFromNATPMP(ctx context.Context, configuration *Settings, source *IPSource) (net.IP, error) {
+	if IsIPv6(configuration) {
+		return nil, errors.New("NAT-PMP only supports IPv4")
+	}
+
+	gateway, err := gatewayAddress(configuration, source, natpmpPort)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// version 0, opcode 0
+	request := []byte{0, 0}
+	return exchangeUDP(ctx, conn, request, gatewayRetransmit, func(response []byte) (net.IP, error) {
+		if len(response) < 12 || response[0] != 0 || response[1] != 128 {
+			return nil, errUnexpectedResponse
+		}
+		if result := binary.BigEndian.Uint16(response[2:]); result != 0 {
+			return nil, fmt.Errorf("NAT-PMP request failed with result code %d", result)
+		}
+		return net.IPv4(response[8], response[9], response[10], response[11]), nil
+	})
+}
+
+// getIPFromPCP sends a PCP (RFC 6887) MAP request to the gateway, and gets the assigned external
+// address from the response. The short lived mapping is deleted afterwards.
+func getIPFromPCP(ctx context.Context, configuration *Settings, source *IPSource) (net.IP, error) {
+	gateway, err := gatewayAddress(configuration, source, natpmpPort)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if IsIPv6(configuration) {
+		network = "udp6"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ip, err := exchangeUDP(ctx, conn, pcpMapRequest(local, nonce, 60), gatewayRetransmit, func(response []byte) (net.IP, error) {
+		return parsePCPResponse(response, nonce)
+	})
+	if err == nil {
+		// best effort, the mapping expires anyway
+		conn.Write(pcpMapRequest(local, nonce, 0))
+	}
+	return ip, err
+}
+
+// pcpMapRequest builds a MAP request of the UDP port of the client, for the lifetime in seconds
+func pcpMapRequest(client *net.UDPAddr, nonce []byte, lifetime uint32) []byte {
+	request := make([]byte, pcpHeaderSize+pcpMapSize)
+	request[0] = pcpVersion
+	request[1] = pcpOpcodeMap
+	binary.BigEndian.PutUint32(request[4:], lifetime)
+	copy(request[8:24], client.IP.To16())
+
+	m := request[pcpHeaderSize:]
+	copy(m[0:12], nonce)
+	m[12] = pcpProtocolUDP
+	binary.BigEndian.PutUint16(m[16:], uint16(client.Port))
+	// no suggested external port and address
+	if client.IP.To4() != nil {
+		copy(m[20:36], net.IPv4zero.To16())
+	}
+	return request
+}
+
+// parsePCPResponse gets the assigned external address from a MAP response of the nonce
+func parsePCPResponse(response []byte, nonce []byte) (net.IP, error) {
+	if len(response) < pcpHeaderSize+pcpMapSize || response[1] != 0x80|pcpOpcodeMap ||
+		!bytes.Equal(response[pcpHeaderSize:pcpHeaderSize+12], nonce) {
+		return nil, errUnexpectedResponse
+	}
+	if response[0] != pcpVersion {
+		return nil, fmt.Errorf("PCP version %d is not supported", response[0])
+	}
+	if result := response[3]; result != pcpResultSuccess {
+		return nil, fmt.Errorf("PCP request failed with result code %d", result)
+	}
+
+	ip := net.IP(append([]byte{}, response[pcpHeaderSize+20:pcpHeaderSize+36]...))
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+// getIPFromUPnP calls GetExternalIPAddress of the Internet Gateway Device. The device description
+// is the url of the source, or discovered with SSDP.
+func getIPFromUPnP(ctx context.Context, configuration *Settings, source *IPSource) (net.IP, error) {
+	location := source.URL
+	if location == "" {
+		target := ssdpAddr
+		if source.Gateway != "" {
+			// ask the gateway directly instead of multicast
+			var err error
+			if target, err = gatewayAddress(configuration, source, 1900); err != nil {
+				return nil, err
+			}
+		}
+
+		// nothing answers SSDP if the gateway doesn't support UPnP
+		ssdpCtx, cancel := context.WithTimeout(ctx, ssdpTimeout)
+		var err error
+		location, err = ssdpDiscover(ssdpCtx, target)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	controlURL, serviceType, err := upnpControlURL(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"></u:GetExternalIPAddress></s:Body>
+</s:Envelope>`
+	req, err := http.NewRequest("POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+
+	response, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetExternalIPAddress returned status %s", response.Status)
+	}
+
+	var envelope struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(envelope.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external IP: %q", envelope.IP)
+	}
+	return ip, nil
+}
+
+// ssdpDiscover searches an Internet Gateway Device, and returns the location of its description
+func ssdpDiscover(ctx context.Context, target string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	request := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultSourceTimeout * time.Second)
+	}
+	retransmit := time.Second
+	buf := make([]byte, 2048)
+	for {
+		if _, err := conn.WriteToUDP([]byte(request), addr); err != nil {
+			return "", err
+		}
+
+		readDeadline := time.Now().Add(retransmit)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		retransmit *= 2
+
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() && time.Now().Before(deadline) && ctx.Err() == nil {
+					break
+				}
+				return "", errors.New("no Internet Gateway Device found")
+			}
+
+			response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+			if err != nil || response.StatusCode != http.StatusOK {
+				continue
+			}
+			if location := response.Header.Get("Location"); location != "" &&
+				strings.Contains(response.Header.Get("St"), "InternetGatewayDevice") {
+				return location, nil
+			}
+		}
+	}
+}
+
+// upnpDevice is a device in the UPnP device description
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// upnpControlURL gets the device description, and returns the control URL and type of
+// the WAN connection service
+func upnpControlURL(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	response, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("device description returned status %s", response.Status)
+	}
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&root); err != nil {
+		return "", "", err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, serviceType := range upnpServices {
+		if controlURL := findUPnPService(&root.Device, serviceType); controlURL != "" {
+			ref, err := url.Parse(controlURL)
+			if err != nil {
+				return "", "", err
+			}
+			return base.ResolveReference(ref).String(), serviceType, nil
+		}
+	}
+	return "", "", errors.New("no WAN connection service in the device")
+}
+
+// findUPnPService returns the control URL of the service in the device or its embedded devices
+func findUPnPService(device *upnpDevice, serviceType string) string {
+	for _, service := range device.Services {
+		if strings.TrimSpace(service.ServiceType) == serviceType {
+			return strings.TrimSpace(service.ControlURL)
+		}
+	}
+	for i := range device.Devices {
+		if controlURL := findUPnPService(&device.Devices[i], serviceType); controlURL != "" {
+			return controlURL
+		}
+	}
+	return ""
+}
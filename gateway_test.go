@@ -0,0 +1,154 @@
+package godns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newGatewayServer answers the NAT-PMP and PCP requests with the external address
+func newGatewayServer(t *testing.T, external net.IP) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			switch {
+			case n == 2 && buf[0] == 0 && buf[1] == 0:
+				response := make([]byte, 12)
+				response[1] = 128
+				copy(response[8:], external.To4())
+				conn.WriteTo(response, addr)
+			case n == pcpHeaderSize+pcpMapSize && buf[0] == pcpVersion && buf[1] == pcpOpcodeMap:
+				response := make([]byte, pcpHeaderSize+pcpMapSize)
+				copy(response, buf[:n])
+				response[1] = 0x80 | pcpOpcodeMap
+				response[3] = pcpResultSuccess
+				binary.BigEndian.PutUint16(response[pcpHeaderSize+18:], 40000)
+				copy(response[pcpHeaderSize+20:], external.To16())
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn
+}
+
+// newIGDServer serves a device description and the WANIPConnection control URL
+func newIGDServer(external string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>%s</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`, external)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGetIPFromGateway(t *testing.T) {
+	gateway := newGatewayServer(t, net.ParseIP("203.0.113.5"))
+	defer gateway.Close()
+
+	for _, protocol := range []string{ProtocolNATPMP, ProtocolPCP} {
+		source := &IPSource{Type: SourceGateway, Gateway: gateway.LocalAddr().String(), Protocols: []string{protocol}, Timeout: 2}
+		if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "203.0.113.5" {
+			t.Errorf("should get the external address with %s, got %s, %v", protocol, ip, err)
+		}
+	}
+
+	igd := newIGDServer("203.0.113.6")
+	defer igd.Close()
+
+	source := &IPSource{Type: SourceGateway, URL: igd.URL + "/rootDesc.xml", Protocols: []string{ProtocolUPnP}, Timeout: 2}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "203.0.113.6" {
+		t.Errorf("should get the external address with UPnP, got %s, %v", ip, err)
+	}
+
+	// fallback to the next protocol
+	source.URL = igd.URL + "/missing.xml"
+	source.Gateway = gateway.LocalAddr().String()
+	source.Protocols = []string{ProtocolUPnP, ProtocolNATPMP}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "203.0.113.5" {
+		t.Errorf("should fallback to NAT-PMP, got %s, %v", ip, err)
+	}
+}
+
+func TestGetIPFromGatewayWithoutUPnP(t *testing.T) {
+	gateway := newGatewayServer(t, net.ParseIP("203.0.113.5"))
+	defer gateway.Close()
+
+	// the gateway doesn't answer SSDP, the default protocols fallback to NAT-PMP in time
+	source := &IPSource{Type: SourceGateway, Gateway: gateway.LocalAddr().String(), Timeout: 3}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "203.0.113.5" {
+		t.Errorf("should fallback to NAT-PMP, got %s, %v", ip, err)
+	}
+}
+
+func TestSSDPDiscover(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil || n == 0 {
+			return
+		}
+		// another device first
+		conn.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: urn:schemas-upnp-org:device:MediaServer:1\r\n"+
+			"LOCATION: http://192.168.1.2/desc.xml\r\n\r\n"), addr)
+		conn.WriteTo([]byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\n"+
+			"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n"+
+			"LOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"), addr)
+	}()
+
+	location, err := ssdpDiscover(context.Background(), conn.LocalAddr().String())
+	if err != nil || location != "http://192.168.1.1:5000/rootDesc.xml" {
+		t.Errorf("should discover the gateway, got %s, %v", location, err)
+	}
+}
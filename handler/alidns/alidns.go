@@ -1,6 +1,7 @@
 package alidns
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -14,7 +15,6 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -35,9 +35,7 @@ var (
 		"SignatureVersion": "1.0",
 		"SignatureNonce":   "",
 	}
-	baseURL  = "http://alidns.aliyuncs.com/"
-	instance *AliDNS
-	once     sync.Once
+	baseURL = "http://alidns.aliyuncs.com/"
 )
 
 type domainRecordsResp struct {
@@ -66,11 +64,17 @@ type DomainRecord struct {
 	Locked     bool
 }
 
-func getHTTPBody(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+func getHTTPBody(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode == http.StatusOK {
 		return body, err
@@ -80,14 +84,11 @@ func getHTTPBody(url string) ([]byte, error) {
 
 // NewAliDNS function creates instance of AliDNS and return
 func NewAliDNS(key, secret string) *AliDNS {
-	once.Do(func() {
-		instance = &AliDNS{
-			AccessKeyID:     key,
-			AccessKeySecret: secret,
-			BaseUrl:         baseURL,
-		}
-	})
-	return instance
+	return &AliDNS{
+		AccessKeyID:     key,
+		AccessKeySecret: secret,
+		BaseUrl:         baseURL,
+	}
 }
 
 func (d *AliDNS) SetBaseUrl(s string) {
@@ -96,30 +97,39 @@ func (d *AliDNS) SetBaseUrl(s string) {
 	}
 }
 
-// GetDomainRecords gets all the doamin records according to input subdomain key
-func (d *AliDNS) GetDomainRecords(domain, rr string) []DomainRecord {
+// GetDomainRecords gets all the doamin records according to input subdomain key and record type
+func (d *AliDNS) GetDomainRecords(ctx context.Context, domain, rr, recordType string) ([]DomainRecord, error) {
 	resp := &domainRecordsResp{}
 	parms := map[string]string{
-		"Action":     "DescribeDomainRecords",
-		"DomainName": domain,
-		"RRKeyWord":  rr,
+		"Action":      "DescribeDomainRecords",
+		"DomainName":  domain,
+		"RRKeyWord":   rr,
+		"TypeKeyWord": recordType,
 	}
 	urlPath := d.genRequestURL(parms)
-	body, err := getHTTPBody(urlPath)
+	body, err := getHTTPBody(ctx, urlPath)
 	if err != nil {
 		fmt.Printf("GetDomainRecords error.%+v\n", err)
-	} else {
-		if err := json.Unmarshal(body, resp); err != nil {
-			fmt.Printf("GetDomainRecords error. %+v\n", err)
-			return nil
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, resp); err != nil {
+		fmt.Printf("GetDomainRecords error. %+v\n", err)
+		return nil, err
+	}
+
+	// RRKeyWord is a fuzzy match, keep the exact ones only
+	var records []DomainRecord
+	for _, r := range resp.DomainRecords.Record {
+		if r.RR == rr && r.Type == recordType {
+			records = append(records, r)
 		}
-		return resp.DomainRecords.Record
 	}
-	return nil
+	return records, nil
 }
 
 // UpdateDomainRecord updates domain record
-func (d *AliDNS) UpdateDomainRecord(r DomainRecord) error {
+func (d *AliDNS) UpdateDomainRecord(ctx context.Context, r DomainRecord) error {
 	parms := map[string]string{
 		"Action":   "UpdateDomainRecord",
 		"RecordId": r.RecordID,
@@ -134,7 +144,7 @@ func (d *AliDNS) UpdateDomainRecord(r DomainRecord) error {
 	if urlPath == "" {
 		return errors.New("Failed to generate request URL")
 	}
-	_, err := getHTTPBody(urlPath)
+	_, err := getHTTPBody(ctx, urlPath)
 	if err != nil {
 		fmt.Printf("UpdateDomainRecord error.%+v\n", err)
 	}
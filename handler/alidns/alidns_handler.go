@@ -1,10 +1,8 @@
 package alidns
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"runtime/debug"
-	"time"
 
 	"github.com/TimothyYe/godns"
 )
@@ -13,6 +11,7 @@ import (
 type Handler struct {
 	Configuration *godns.Settings
 	API           string
+	aliDNS        *AliDNS
 }
 
 // SetConfiguration pass dns settings and store it to handler instance
@@ -21,66 +20,49 @@ func (handler *Handler) SetConfiguration(conf *godns.Settings) {
 	if conf.Api != "" {
 		handler.API = conf.Api
 	}
-}
-
-// DomainLoop the main logic loop
-func (handler *Handler) DomainLoop(domain *godns.Domain, panicChan chan<- godns.Domain) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered in %v: %v\n", err, debug.Stack())
-			panicChan <- *domain
-		}
-	}()
 
-	var lastIP string
-	aliDNS := NewAliDNS(handler.Configuration.Email, handler.Configuration.Password)
+	handler.aliDNS = NewAliDNS(conf.Email, conf.Password)
 	if handler.API != "" {
-		aliDNS.SetBaseUrl(handler.API)
+		handler.aliDNS.SetBaseUrl(handler.API)
 	}
+}
 
-	for {
-		currentIP, err := godns.GetCurrentIP(handler.Configuration)
-
+// GetRecords returns the records of the configured sub domains with the specific type
+func (handler *Handler) GetRecords(ctx context.Context, domain *godns.Domain, recordType string) ([]*godns.Record, error) {
+	var records []*godns.Record
+	for _, subDomain := range domain.SubDomains {
+		domainRecords, err := handler.aliDNS.GetDomainRecords(ctx, domain.DomainName, subDomain, recordType)
 		if err != nil {
-			log.Println("Failed to get current IP:", err)
+			return nil, fmt.Errorf("cannot get subdomain %s from AliDNS: %v", subDomain, err)
+		}
+		if len(domainRecords) == 0 {
 			continue
 		}
-		log.Println("currentIP is:", currentIP)
 
-		//check against locally cached IP, if no change, skip update
-		if currentIP == lastIP {
-			log.Printf("IP is the same as cached one. Skip update.\n")
-		} else {
-			lastIP = currentIP
-
-			for _, subDomain := range domain.SubDomains {
-				log.Printf("%s.%s Start to update record IP...\n", subDomain, domain.DomainName)
-				records := aliDNS.GetDomainRecords(domain.DomainName, subDomain)
-				if records == nil || len(records) == 0 {
-					log.Printf("Cannot get subdomain %s from AliDNS.\r\n", subDomain)
-					continue
-				}
+		records = append(records, &godns.Record{
+			ID:        domainRecords[0].RecordID,
+			Domain:    domain.DomainName,
+			SubDomain: subDomain,
+			Type:      domainRecords[0].Type,
+			IP:        domainRecords[0].Value,
+		})
+	}
+	return records, nil
+}
 
-				records[0].Value = currentIP
-				if err := aliDNS.UpdateDomainRecord(records[0]); err != nil {
-					log.Printf("Failed to update IP for subdomain:%s\r\n", subDomain)
-					continue
-				} else {
-					log.Printf("IP updated for subdomain:%s\r\n", subDomain)
-				}
+// UpdateRecord updates the record with new IP. The record is read again first, since AliDNS
+// resets the TTL and line of the record if they are not given.
+func (handler *Handler) UpdateRecord(ctx context.Context, record *godns.Record, ip string) error {
+	domainRecords, err := handler.aliDNS.GetDomainRecords(ctx, record.Domain, record.SubDomain, record.Type)
+	if err != nil {
+		return fmt.Errorf("cannot get subdomain %s from AliDNS: %v", record.SubDomain, err)
+	}
 
-				// Send mail notification if notify is enabled
-				if handler.Configuration.Notify.Enabled {
-					log.Print("Sending notification to:", handler.Configuration.Notify.SendTo)
-					if err := godns.SendNotify(handler.Configuration, fmt.Sprintf("%s.%s", subDomain, domain.DomainName), currentIP); err != nil {
-						log.Printf("Failed to send notification")
-					}
-				}
-			}
+	for _, r := range domainRecords {
+		if r.RecordID == record.ID {
+			r.Value = ip
+			return handler.aliDNS.UpdateDomainRecord(ctx, r)
 		}
-		// Sleep with interval
-		log.Printf("Going to sleep, will start next checking in %d seconds...\r\n", handler.Configuration.Interval)
-		time.Sleep(time.Second * time.Duration(handler.Configuration.Interval))
 	}
-
+	return fmt.Errorf("record %s not found in AliDNS", record.ID)
 }
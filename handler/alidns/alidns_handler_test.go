@@ -0,0 +1,48 @@
+package alidns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TimothyYe/godns"
+)
+
+func TestUpdateRecordKeepsTTLAndLine(t *testing.T) {
+	var updated map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch query.Get("Action") {
+		case "DescribeDomainRecords":
+			fmt.Fprint(w, `{"DomainRecords": {"Record": [
+				{"RecordId": "1", "RR": "www", "Type": "A", "Value": "1.1.1.1", "TTL": 60, "Line": "telecom"},
+				{"RecordId": "2", "RR": "www", "Type": "A", "Value": "1.1.1.1", "TTL": 600, "Line": "default"}
+			]}}`)
+		case "UpdateDomainRecord":
+			updated = map[string]string{}
+			for key := range query {
+				updated[key] = query.Get(key)
+			}
+			fmt.Fprint(w, `{"RecordId": "1"}`)
+		}
+	}))
+	defer server.Close()
+
+	handler := &Handler{}
+	handler.SetConfiguration(&godns.Settings{Email: "key", Password: "secret", Api: server.URL + "/"})
+
+	record := &godns.Record{ID: "1", Domain: "example.com", SubDomain: "www", Type: "A", IP: "1.1.1.1"}
+	if err := handler.UpdateRecord(context.Background(), record, "2.2.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if updated["RecordId"] != "1" || updated["Value"] != "2.2.2.2" || updated["TTL"] != "60" || updated["Line"] != "telecom" {
+		t.Errorf("TTL and line of the record should be kept, got %v", updated)
+	}
+
+	record.ID = "3"
+	if err := handler.UpdateRecord(context.Background(), record, "2.2.2.2"); err == nil {
+		t.Error("record is gone, should be failed")
+	}
+}
@@ -2,28 +2,51 @@ package cloudflare
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"runtime/debug"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/TimothyYe/godns"
 )
 
+// perPage is the page size of the list requests
+const perPage = 50
+
 // Handler struct definition
 type Handler struct {
 	Configuration *godns.Settings
 	API           string
+
+	// zones and records cache the zone IDs by domain, and the records with their IDs and
+	// contents by name and type, for the life of the process
+	mu      sync.Mutex
+	zones   map[string]string
+	records map[string]godns.Record
 }
 
 // DNSRecordResponse struct
 type DNSRecordResponse struct {
-	Records []DNSRecord `json:"result"`
-	Success bool        `json:"success"`
+	Records    []DNSRecord `json:"result"`
+	ResultInfo ResultInfo  `json:"result_info"`
+	Success    bool        `json:"success"`
+}
+
+// ResultInfo is the pagination of a list response
+type ResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
 }
 
 // DNSRecordUpdateResponse struct
@@ -50,8 +73,9 @@ func (r *DNSRecord) SetIP(ip string) {
 
 // ZoneResponse is a wrapper for Zones
 type ZoneResponse struct {
-	Zones   []Zone `json:"result"`
-	Success bool   `json:"success"`
+	Zones      []Zone     `json:"result"`
+	ResultInfo ResultInfo `json:"result_info"`
+	Success    bool       `json:"success"`
 }
 
 // Zone object with id and name
@@ -60,6 +84,18 @@ type Zone struct {
 	Name string `json:"name"`
 }
 
+// TokenVerifyResponse struct
+type TokenVerifyResponse struct {
+	Result  Token `json:"result"`
+	Success bool  `json:"success"`
+}
+
+// Token object with id and status
+type Token struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
 // SetConfiguration pass dns settings and store it to handler instance
 func (handler *Handler) SetConfiguration(conf *godns.Settings) {
 	handler.Configuration = conf
@@ -70,63 +106,212 @@ func (handler *Handler) SetConfiguration(conf *godns.Settings) {
 	}
 }
 
-// DomainLoop the main logic loop
-func (handler *Handler) DomainLoop(domain *godns.Domain, panicChan chan<- godns.Domain) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered in %v: %v\n", err, debug.Stack())
-			panicChan <- *domain
+// GetRecords returns the tracked records of the domain with the specific type, each of them is
+// looked up by name once and then kept up to date in the cache
+func (handler *Handler) GetRecords(ctx context.Context, domain *godns.Domain, recordType string) ([]*godns.Record, error) {
+	// a token scoped to the zone can't list the zones
+	zoneID := domain.ZoneID
+	if zoneID != "" {
+		handler.cacheZone(domain.DomainName, zoneID)
+	} else {
+		var err error
+		if zoneID, err = handler.getZone(ctx, domain.DomainName); err != nil {
+			return nil, err
 		}
-	}()
+	}
+	if zoneID == "" {
+		return nil, fmt.Errorf("failed to find zone for domain: %s", domain.DomainName)
+	}
 
-	var lastIP string
-	for {
-		currentIP, err := godns.GetCurrentIP(handler.Configuration)
-		if err != nil {
-			log.Println("Error in GetCurrentIP:", err)
+	var records []*godns.Record
+	for _, subDomain := range domain.SubDomains {
+		name := fmt.Sprintf("%s.%s", subDomain, domain.DomainName)
+		if record, ok := handler.cachedRecord(name, recordType); ok {
+			records = append(records, &record)
 			continue
 		}
-		log.Println("Current IP is:", currentIP)
-		//check against locally cached IP, if no change, skip update
-		if currentIP == lastIP {
-			log.Printf("IP is the same as cached one. Skip update.\n")
-		} else {
-			lastIP = currentIP
-
-			log.Println("Checking IP for domain", domain.DomainName)
-			zoneID := handler.getZone(domain.DomainName)
-			if zoneID != "" {
-				records := handler.getDNSRecords(zoneID)
-
-				// update records
-				for _, rec := range records {
-					if !recordTracked(domain, &rec) {
-						log.Println("Skiping record:", rec.Name)
-						continue
-					}
-					if rec.IP != currentIP {
-						log.Printf("IP mismatch: Current(%+v) vs Cloudflare(%+v)\r\n", currentIP, rec.IP)
-						handler.updateRecord(rec, currentIP)
-
-						// Send mail notification if notify is enabled
-						if handler.Configuration.Notify.Enabled {
-							log.Print("Sending notification to:", handler.Configuration.Notify.SendTo)
-							if err := godns.SendNotify(handler.Configuration, rec.Name, currentIP); err != nil {
-								log.Println("Failed to send notification")
-							}
-						}
-					} else {
-						log.Printf("Record OK: %+v - %+v\r\n", rec.Name, rec.IP)
-					}
-				}
-			} else {
-				log.Println("Failed to find zone for domain:", domain.DomainName)
+
+		dnsRecords, err := handler.getDNSRecords(ctx, zoneID, recordType, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range dnsRecords {
+			if !recordTracked(domain, &rec) {
+				log.Println("Skiping record:", rec.Name)
+				continue
+			}
+
+			record := &godns.Record{
+				ID:        rec.ID,
+				ZoneID:    zoneID,
+				Domain:    domain.DomainName,
+				SubDomain: strings.TrimSuffix(rec.Name, "."+domain.DomainName),
+				Type:      rec.Type,
+				IP:        rec.IP,
 			}
+			handler.cacheRecord(record, rec.IP)
+			records = append(records, record)
 		}
-		// Sleep with interval
-		log.Printf("Going to sleep, will start next checking in %d seconds...\r\n", handler.Configuration.Interval)
-		time.Sleep(time.Second * time.Duration(handler.Configuration.Interval))
 	}
+	return records, nil
+}
+
+// UpdateRecord updates the record with new IP
+func (handler *Handler) UpdateRecord(ctx context.Context, record *godns.Record, ip string) error {
+	if err := handler.updateRecord(ctx, record, ip); err != nil {
+		// the record may be deleted, look it up again next time
+		handler.forgetRecord(record)
+		return err
+	}
+	handler.cacheRecord(record, ip)
+	return nil
+}
+
+// CreateRecord creates the record with its options, the TTL is automatic and the record
+// is not proxied if they are not set
+func (handler *Handler) CreateRecord(ctx context.Context, record *godns.Record, ip string) error {
+	zoneID := record.ZoneID
+	if zoneID == "" {
+		var err error
+		if zoneID, err = handler.getZone(ctx, record.Domain); err != nil {
+			return err
+		}
+		if zoneID == "" {
+			return fmt.Errorf("failed to find zone for domain: %s", record.Domain)
+		}
+	}
+
+	var r DNSRecordUpdateResponse
+
+	fields := recordFields(ip, record.Options)
+	fields["type"] = record.Type
+	fields["name"] = record.Name()
+	if _, ok := fields["ttl"]; !ok {
+		fields["ttl"] = godns.TTLAuto
+	}
+
+	j, _ := json.Marshal(fields)
+	req, client := handler.newRequest(ctx, "POST", "/zones/"+zoneID+"/dns_records", bytes.NewBuffer(j))
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Request error:", err.Error())
+		return err
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		log.Printf("Decoder error: %+v\n", err)
+		log.Printf("Response body: %+v\n", string(body))
+		return err
+	}
+	if r.Success != true {
+		log.Printf("Response failed: %+v\n", string(body))
+		return errors.New("failed to create DNS record")
+	}
+
+	record.ID = r.Record.ID
+	record.ZoneID = zoneID
+	handler.cacheRecord(record, ip)
+	log.Printf("Record created: %+v - %+v", r.Record.Name, r.Record.IP)
+	return nil
+}
+
+// recordFields returns the fields to set on the record, the content and the options which are set
+func recordFields(ip string, options *godns.RecordOptions) map[string]interface{} {
+	fields := map[string]interface{}{"content": ip}
+	if options == nil {
+		return fields
+	}
+
+	if options.Proxied != nil {
+		fields["proxied"] = *options.Proxied
+	}
+	if options.TTL != 0 {
+		fields["ttl"] = options.TTL
+	}
+	if options.Comment != "" {
+		fields["comment"] = options.Comment
+	}
+	if options.Tags != nil {
+		fields["tags"] = options.Tags
+	}
+	return fields
+}
+
+// cacheZone caches the ID of the zone
+func (handler *Handler) cacheZone(domain, zoneID string) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.zones == nil {
+		handler.zones = map[string]string{}
+	}
+	handler.zones[domain] = zoneID
+}
+
+// cachedRecord returns a copy of the cached record
+func (handler *Handler) cachedRecord(name, recordType string) (godns.Record, bool) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	record, ok := handler.records[name+"/"+recordType]
+	return record, ok
+}
+
+// cacheRecord caches the record pointing to the IP, without its options
+func (handler *Handler) cacheRecord(record *godns.Record, ip string) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.records == nil {
+		handler.records = map[string]godns.Record{}
+	}
+	cached := *record
+	cached.IP = ip
+	cached.Options = nil
+	handler.records[record.Name()+"/"+record.Type] = cached
+}
+
+// forgetRecord removes the record from the cache
+func (handler *Handler) forgetRecord(record *godns.Record) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	delete(handler.records, record.Name()+"/"+record.Type)
+}
+
+// Verify checks that the API token is active, the Global API Key is not checked
+func (handler *Handler) Verify(ctx context.Context) error {
+	if handler.Configuration.LoginToken == "" {
+		return nil
+	}
+
+	var r TokenVerifyResponse
+
+	req, client := handler.newRequest(ctx, "GET", "/user/tokens/verify", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Request error:", err.Error())
+		return err
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		log.Printf("Decoder error: %+v\n", err)
+		log.Printf("Response body: %+v\n", string(body))
+		return err
+	}
+	if r.Success != true {
+		log.Printf("Response failed: %+v\n", string(body))
+		return errors.New("failed to verify API token")
+	}
+	if r.Result.Status != "active" {
+		return fmt.Errorf("API token is %s", r.Result.Status)
+	}
+
+	log.Println("Cloudflare API token verified:", r.Result.ID)
+	return nil
 }
 
 // Check if record is present in domain conf
@@ -142,108 +327,134 @@ func recordTracked(domain *godns.Domain, record *DNSRecord) bool {
 }
 
 // Create a new request with auth in place and optional proxy
-func (handler *Handler) newRequest(method, url string, body io.Reader) (*http.Request, *http.Client) {
+func (handler *Handler) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, *http.Client) {
 	client := godns.GetHttpClient(handler.Configuration)
 	if client == nil {
 		log.Println("cannot create HTTP client")
 	}
 
 	req, _ := http.NewRequest(method, handler.API+url, body)
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Auth-Email", handler.Configuration.Email)
-	req.Header.Set("X-Auth-Key", handler.Configuration.Password)
+	if handler.Configuration.LoginToken != "" {
+		req.Header.Set("Authorization", "Bearer "+handler.Configuration.LoginToken)
+	} else {
+		req.Header.Set("X-Auth-Email", handler.Configuration.Email)
+		req.Header.Set("X-Auth-Key", handler.Configuration.Password)
+	}
 	return req, client
 }
 
-// Find the correct zone via domain name
-func (handler *Handler) getZone(domain string) string {
+// Find the correct zone via domain name, the zone ID is cached
+func (handler *Handler) getZone(ctx context.Context, domain string) (string, error) {
+	handler.mu.Lock()
+	zoneID, ok := handler.zones[domain]
+	handler.mu.Unlock()
+	if ok {
+		return zoneID, nil
+	}
 
-	var z ZoneResponse
+	query := url.Values{"name": {domain}}
+	for page := 1; ; page++ {
+		var z ZoneResponse
 
-	req, client := handler.newRequest("GET", "/zones", nil)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Request error:", err.Error())
-		return ""
-	}
+		body, err := handler.getPage(ctx, "/zones", query, page, &z)
+		if err != nil {
+			return "", err
+		}
+		if z.Success != true {
+			log.Printf("Response failed: %+v\n", string(body))
+			return "", errors.New("failed to get zones")
+		}
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &z)
-	if err != nil {
-		log.Printf("Decoder error: %+v\n", err)
-		log.Printf("Response body: %+v\n", string(body))
-		return ""
-	}
-	if z.Success != true {
-		log.Printf("Response failed: %+v\n", string(body))
-		return ""
+		for _, zone := range z.Zones {
+			if zone.Name == domain {
+				handler.cacheZone(domain, zone.ID)
+				return zone.ID, nil
+			}
+		}
+		if page >= z.ResultInfo.TotalPages {
+			return "", nil
+		}
 	}
+}
+
+// Get the DNS records with the specific type and name for a zone
+func (handler *Handler) getDNSRecords(ctx context.Context, zoneID, recordType, name string) ([]DNSRecord, error) {
+	var records []DNSRecord
+
+	query := url.Values{"type": {recordType}, "name": {name}}
+	for page := 1; ; page++ {
+		var r DNSRecordResponse
+
+		body, err := handler.getPage(ctx, "/zones/"+zoneID+"/dns_records", query, page, &r)
+		if err != nil {
+			return nil, err
+		}
+		if r.Success != true {
+			log.Printf("Response failed: %+v\n", string(body))
+			return nil, errors.New("failed to get DNS records")
+		}
 
-	for _, zone := range z.Zones {
-		if zone.Name == domain {
-			return zone.ID
+		records = append(records, r.Records...)
+		if page >= r.ResultInfo.TotalPages {
+			return records, nil
 		}
 	}
-	return ""
 }
 
-// Get all DNS A records for a zone
-func (handler *Handler) getDNSRecords(zoneID string) []DNSRecord {
-
-	var empty []DNSRecord
-	var r DNSRecordResponse
+// getPage gets a page of a list and decodes it, the body is returned for logging
+func (handler *Handler) getPage(ctx context.Context, path string, query url.Values, page int, v interface{}) ([]byte, error) {
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
 
-	req, client := handler.newRequest("GET", "/zones/"+zoneID+"/dns_records?type=A", nil)
+	req, client := handler.newRequest(ctx, "GET", path+"?"+query.Encode(), nil)
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Println("Request error:", err.Error())
-		return empty
+		return nil, err
 	}
 
+	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &r)
+	err = json.Unmarshal(body, v)
 	if err != nil {
 		log.Printf("Decoder error: %+v\n", err)
 		log.Printf("Response body: %+v\n", string(body))
-		return empty
+		return nil, err
 	}
-	if r.Success != true {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("Response failed: %+v\n", string(body))
-		return empty
-
-	}
-	return r.Records
+	return body, nil
 }
 
-// Update DNS A Record with new IP
-func (handler *Handler) updateRecord(record DNSRecord, newIP string) {
+// Update the content of a DNS record with new IP and its options, other fields are kept
+func (handler *Handler) updateRecord(ctx context.Context, record *godns.Record, newIP string) error {
 
 	var r DNSRecordUpdateResponse
-	record.SetIP(newIP)
 
-	j, _ := json.Marshal(record)
-	req, client := handler.newRequest("PUT",
+	j, _ := json.Marshal(recordFields(newIP, record.Options))
+	req, client := handler.newRequest(ctx, "PATCH",
 		"/zones/"+record.ZoneID+"/dns_records/"+record.ID,
 		bytes.NewBuffer(j),
 	)
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Println("Request error:", err.Error())
-		return
+		return err
 	}
 
+	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 	err = json.Unmarshal(body, &r)
 	if err != nil {
 		log.Printf("Decoder error: %+v\n", err)
 		log.Printf("Response body: %+v\n", string(body))
-		return
+		return err
 	}
 	if r.Success != true {
-		body, _ := ioutil.ReadAll(resp.Body)
 		log.Printf("Response failed: %+v\n", string(body))
-	} else {
-		log.Printf("Record updated: %+v - %+v", record.Name, record.IP)
+		return errors.New("failed to update DNS record")
 	}
+
+	log.Printf("Record updated: %+v - %+v", r.Record.Name, r.Record.IP)
+	return nil
 }
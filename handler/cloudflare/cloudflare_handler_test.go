@@ -1,7 +1,13 @@
 package cloudflare
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -165,3 +171,166 @@ func TestRecordTracked(t *testing.T) {
 		}
 	}
 }
+
+// newTokenServer is a Cloudflare API which only accepts the token, and has no access to the zones
+func newTokenServer(t *testing.T, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token || r.Header.Get("X-Auth-Key") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/user/tokens/verify":
+			fmt.Fprint(w, `{"success": true, "result": {"id": "ed17574386854bf78a67040be0a770b0", "status": "active"}}`)
+		case "/zones/mk2b6fa491c12445a4376666a32429e1/dns_records":
+			fmt.Fprint(w, `{"success": true, "result": [{"id": "F11cc63e02a42d38174b8e7c548a7b6f", "name": "www.example.com",
+				"type": "A", "content": "1.1.1.1", "zone_id": "mk2b6fa491c12445a4376666a32429e1"}]}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success": false, "errors": [{"code": 10000, "message": "Authentication error"}]}`)
+		}
+	}))
+}
+
+func TestAPIToken(t *testing.T) {
+	server := newTokenServer(t, "token")
+	defer server.Close()
+
+	handler := &Handler{}
+	handler.SetConfiguration(&godns.Settings{LoginToken: "token", Api: server.URL})
+	if err := handler.Verify(context.Background()); err != nil {
+		t.Error("token should be verified:", err)
+	}
+
+	domain := &godns.Domain{DomainName: "example.com", SubDomains: []string{"www"}, ZoneID: "mk2b6fa491c12445a4376666a32429e1"}
+	records, err := handler.GetRecords(context.Background(), domain, "A")
+	if err != nil || len(records) != 1 || records[0].ID != "F11cc63e02a42d38174b8e7c548a7b6f" {
+		t.Errorf("records should be got with the zone ID, got %v, %v", records, err)
+	}
+
+	// the zones can't be listed with the token
+	handler = &Handler{}
+	handler.SetConfiguration(&godns.Settings{LoginToken: "token", Api: server.URL})
+	domain.ZoneID = ""
+	if _, err := handler.GetRecords(context.Background(), domain, "A"); err == nil {
+		t.Error("zones are not accessible with the token, should be failed")
+	}
+
+	handler.SetConfiguration(&godns.Settings{LoginToken: "expired", Api: server.URL})
+	if err := handler.Verify(context.Background()); err == nil {
+		t.Error("invalid token, should be failed")
+	}
+}
+
+// newPagedServer is a Cloudflare API which ignores the name filter of the zones and returns one
+// zone per page, and counts the requests of the DNS records
+func newPagedServer(t *testing.T, zones []string, lookups map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, _ := strconv.Atoi(query.Get("page"))
+
+		switch {
+		case r.URL.Path == "/zones":
+			if page < 1 || page > len(zones) {
+				t.Errorf("unexpected page: %d", page)
+				return
+			}
+			fmt.Fprintf(w, `{"success": true, "result": [{"id": "zone%d", "name": %q}],
+				"result_info": {"page": %d, "per_page": 1, "total_pages": %d}}`, page, zones[page-1], page, len(zones))
+		case strings.HasPrefix(r.URL.Path, "/zones/") && strings.HasSuffix(r.URL.Path, "/dns_records"):
+			name, recordType := query.Get("name"), query.Get("type")
+			lookups[name+"/"+recordType]++
+			fmt.Fprintf(w, `{"success": true, "result": [{"id": "id-%s", "name": %q, "type": %q, "content": "1.1.1.1"}],
+				"result_info": {"page": 1, "per_page": 50, "total_pages": 1}}`, name, name, recordType)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetRecordsPaged(t *testing.T) {
+	lookups := map[string]int{}
+	server := newPagedServer(t, []string{"example.net", "example.org", "example.com"}, lookups)
+	defer server.Close()
+
+	handler := &Handler{}
+	handler.SetConfiguration(&godns.Settings{LoginToken: "token", Api: server.URL})
+
+	domain := &godns.Domain{DomainName: "example.com", SubDomains: []string{"www", "test"}}
+	records, err := handler.GetRecords(context.Background(), domain, "A")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records should be got, got %v, %v", records, err)
+	}
+	if records[0].ZoneID != "zone3" || records[0].ID != "id-www.example.com" || records[0].IP != "1.1.1.1" {
+		t.Errorf("record should be in the zone on the last page, got %+v", records[0])
+	}
+	if len(lookups) != 2 || lookups["www.example.com/A"] != 1 || lookups["test.example.com/A"] != 1 {
+		t.Errorf("each record should be looked up by name and type, got %v", lookups)
+	}
+
+	// the zone and record IDs are cached
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("cached IDs should be used, got request %s", r.URL)
+	})
+	records, err = handler.GetRecords(context.Background(), domain, "A")
+	if err != nil || len(records) != 2 || records[1].ID != "id-test.example.com" || records[1].ZoneID != "zone3" {
+		t.Errorf("cached records should be got, got %v, %v", records, err)
+	}
+	// with their content, so that the up-to-date ones are not updated again
+	for _, record := range records {
+		if record.IP != "1.1.1.1" {
+			t.Errorf("cached record should have its content, got %+v", record)
+		}
+	}
+}
+
+func TestCreateAndUpdateRecord(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		fmt.Fprint(w, `{"success": true, "result": {"id": "F11cc63e02a42d38174b8e7c548a7b6f", "name": "www.example.com", "content": "1.1.1.1"}}`)
+	}))
+	defer server.Close()
+
+	handler := &Handler{}
+	handler.SetConfiguration(&godns.Settings{LoginToken: "token", Api: server.URL})
+	handler.cacheZone("example.com", "zone")
+
+	// a missing record is created with the automatic TTL
+	record := &godns.Record{Domain: "example.com", SubDomain: "www", Type: "A"}
+	if err := handler.CreateRecord(context.Background(), record, "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if record.ID != "F11cc63e02a42d38174b8e7c548a7b6f" || record.ZoneID != "zone" {
+		t.Errorf("created record should get its ID, got %+v", record)
+	}
+	if cached, ok := handler.cachedRecord("www.example.com", "A"); !ok || cached.ID != record.ID || cached.IP != "1.1.1.1" {
+		t.Error("created record should be cached")
+	}
+
+	// the options set are sent with the new IP
+	proxied := false
+	record.Options = &godns.RecordOptions{SubDomain: "www", Proxied: &proxied, TTL: 300, Tags: []string{"godns"}}
+	if err := handler.UpdateRecord(context.Background(), record, "2.2.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if cached, ok := handler.cachedRecord("www.example.com", "A"); !ok || cached.IP != "2.2.2.2" {
+		t.Errorf("updated record should be cached with the new IP, got %+v", cached)
+	}
+
+	expected := []string{
+		`POST /zones/zone/dns_records {"content":"1.1.1.1","name":"www.example.com","ttl":1,"type":"A"}`,
+		`PATCH /zones/zone/dns_records/F11cc63e02a42d38174b8e7c548a7b6f {"content":"2.2.2.2","proxied":false,"tags":["godns"],"ttl":300}`,
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("request should be %s, got %s", expected[i], requests[i])
+		}
+	}
+}
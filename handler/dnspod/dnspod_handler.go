@@ -1,6 +1,7 @@
 package dnspod
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,15 +9,16 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"runtime/debug"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/TimothyYe/godns"
 	simplejson "github.com/bitly/go-simplejson"
 )
 
+// codeNoRecords is the status code of Record.List when the sub domain has no records
+const codeNoRecords = "10"
+
 // Handler struct definition
 type Handler struct {
 	Configuration *godns.Settings
@@ -33,69 +35,47 @@ func (handler *Handler) SetConfiguration(conf *godns.Settings) {
 	}
 }
 
-// DomainLoop the main logic loop
-func (handler *Handler) DomainLoop(domain *godns.Domain, panicChan chan<- godns.Domain) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered in %v: %v\n", err, debug.Stack())
-			panicChan <- *domain
-		}
-	}()
-
-	var lastIP string
-	for {
-		log.Printf("Checking IP for domain %s \r\n", domain.DomainName)
-		domainID := handler.GetDomain(domain.DomainName)
+// GetRecords returns the records of the configured sub domains with the specific type
+func (handler *Handler) GetRecords(ctx context.Context, domain *godns.Domain, recordType string) ([]*godns.Record, error) {
+	domainID := handler.GetDomain(ctx, domain.DomainName)
+	if domainID == -1 {
+		return nil, errors.New("failed to get domain list")
+	}
+	if domainID == 0 {
+		return nil, fmt.Errorf("domain %s not found", domain.DomainName)
+	}
 
-		if domainID == -1 {
-			continue
+	var records []*godns.Record
+	for _, subDomain := range domain.SubDomains {
+		subDomainID, ip, err := handler.GetSubDomain(ctx, domainID, subDomain, recordType)
+		if err != nil {
+			return nil, err
 		}
 
-		currentIP, err := godns.GetCurrentIP(handler.Configuration)
-
-		if err != nil {
-			log.Println("get_currentIP:", err)
+		if subDomainID == "" || ip == "" {
+			log.Printf("Domain or subdomain not configured yet. domain: %s.%s subDomainID: %s ip: %s\n", subDomain, domain.DomainName, subDomainID, ip)
 			continue
 		}
-		log.Println("currentIP is:", currentIP)
-
-		//check against locally cached IP, if no change, skip update
-		if currentIP == lastIP {
-			log.Printf("IP is the same as cached one. Skip update.\n")
-		} else {
-			lastIP = currentIP
-
-			for _, subDomain := range domain.SubDomains {
 
-				subDomainID, ip := handler.GetSubDomain(domainID, subDomain)
-
-				if subDomainID == "" || ip == "" {
-					log.Printf("Domain or subdomain not configured yet. domain: %s.%s subDomainID: %s ip: %s\n", subDomain, domain.DomainName, subDomainID, ip)
-					continue
-				}
+		records = append(records, &godns.Record{
+			ID:        subDomainID,
+			ZoneID:    strconv.FormatInt(domainID, 10),
+			Domain:    domain.DomainName,
+			SubDomain: subDomain,
+			Type:      recordType,
+			IP:        strings.TrimRight(ip, "\n"),
+		})
+	}
+	return records, nil
+}
 
-				// Continue to check the IP of subdomain
-				if len(ip) > 0 && strings.TrimRight(currentIP, "\n") != strings.TrimRight(ip, "\n") {
-					log.Printf("%s.%s Start to update record IP...\n", subDomain, domain.DomainName)
-					handler.UpdateIP(domainID, subDomainID, subDomain, currentIP)
-
-					// Send mail notification if notify is enabled
-					if handler.Configuration.Notify.Enabled {
-						log.Print("Sending notification to:", handler.Configuration.Notify.SendTo)
-						if err := godns.SendNotify(handler.Configuration, fmt.Sprintf("%s.%s", subDomain, domain.DomainName), currentIP); err != nil {
-							log.Println("Failed to send notification")
-						}
-					}
-
-				} else {
-					log.Printf("%s.%s Current IP is same as domain IP, no need to update...\n", subDomain, domain.DomainName)
-				}
-			}
-		}
-		// Sleep with interval
-		log.Printf("Going to sleep, will start next checking in %d seconds...\r\n", handler.Configuration.Interval)
-		time.Sleep(time.Second * time.Duration(handler.Configuration.Interval))
+// UpdateRecord updates the record with new IP
+func (handler *Handler) UpdateRecord(ctx context.Context, record *godns.Record, ip string) error {
+	domainID, err := strconv.ParseInt(record.ZoneID, 10, 64)
+	if err != nil {
+		return err
 	}
+	return handler.UpdateIP(ctx, domainID, record.ID, record.SubDomain, record.Type, ip)
 }
 
 // GenerateHeader generates the request header for DNSPod API
@@ -119,7 +99,7 @@ func (handler *Handler) GenerateHeader(content url.Values) url.Values {
 }
 
 // GetDomain returns specific domain by name
-func (handler *Handler) GetDomain(name string) int64 {
+func (handler *Handler) GetDomain(ctx context.Context, name string) int64 {
 
 	var ret int64
 	values := url.Values{}
@@ -127,7 +107,7 @@ func (handler *Handler) GetDomain(name string) int64 {
 	values.Add("offset", "0")
 	values.Add("length", "20")
 
-	response, err := handler.PostData("/Domain.List", values)
+	response, err := handler.PostData(ctx, "/Domain.List", values)
 
 	if err != nil {
 		log.Println("Failed to get domain list...")
@@ -168,7 +148,7 @@ func (handler *Handler) GetDomain(name string) int64 {
 }
 
 // GetSubDomain returns subdomain by domain id
-func (handler *Handler) GetSubDomain(domainID int64, name string) (string, string) {
+func (handler *Handler) GetSubDomain(ctx context.Context, domainID int64, name, recordType string) (string, string, error) {
 	log.Println("debug:", domainID, name)
 	var ret, ip string
 	value := url.Values{}
@@ -176,22 +156,24 @@ func (handler *Handler) GetSubDomain(domainID int64, name string) (string, strin
 	value.Add("offset", "0")
 	value.Add("length", "1")
 	value.Add("sub_domain", name)
+	value.Add("record_type", recordType)
 
-	response, err := handler.PostData("/Record.List", value)
+	response, err := handler.PostData(ctx, "/Record.List", value)
 
 	if err != nil {
 		log.Println("Failed to get domain list")
-		return "", ""
+		return "", "", err
 	}
 
 	sjson, parseErr := simplejson.NewJson([]byte(response))
 
 	if parseErr != nil {
 		log.Println(parseErr)
-		return "", ""
+		return "", "", parseErr
 	}
 
-	if sjson.Get("status").Get("code").MustString() == "1" {
+	code := sjson.Get("status").Get("code").MustString()
+	if code == "1" {
 		records, _ := sjson.Get("records").Array()
 
 		for _, d := range records {
@@ -205,46 +187,51 @@ func (handler *Handler) GetSubDomain(domainID int64, name string) (string, strin
 		if len(records) == 0 {
 			log.Println("records slice is empty.")
 		}
+	} else if code == codeNoRecords {
+		log.Println("records slice is empty.")
 	} else {
-		log.Println("get_subdomain:status code:", sjson.Get("status").Get("code").MustString())
+		// e.g. a bad token or rate limit, not a missing record
+		return "", "", fmt.Errorf("get_subdomain:status code: %s, %s", code, sjson.Get("status").Get("message").MustString())
 	}
 
-	return ret, ip
+	return ret, ip, nil
 }
 
 // UpdateIP update subdomain with current IP
-func (handler *Handler) UpdateIP(domainID int64, subDomainID string, subDomainName string, ip string) {
+func (handler *Handler) UpdateIP(ctx context.Context, domainID int64, subDomainID, subDomainName, recordType, ip string) error {
 	value := url.Values{}
 	value.Add("domain_id", strconv.FormatInt(domainID, 10))
 	value.Add("record_id", subDomainID)
 	value.Add("sub_domain", subDomainName)
-	value.Add("record_type", "A")
+	value.Add("record_type", recordType)
 	value.Add("record_line", "默认")
 	value.Add("value", ip)
 
-	response, err := handler.PostData("/Record.Modify", value)
+	response, err := handler.PostData(ctx, "/Record.Modify", value)
 
 	if err != nil {
 		log.Println("Failed to update record to new IP!")
 		log.Println(err)
-		return
+		return err
 	}
 
 	sjson, parseErr := simplejson.NewJson([]byte(response))
 
 	if parseErr != nil {
 		log.Println(parseErr)
-		return
+		return parseErr
 	}
 
-	if sjson.Get("status").Get("code").MustString() == "1" {
-		log.Println("New IP updated!")
+	if code := sjson.Get("status").Get("code").MustString(); code != "1" {
+		return fmt.Errorf("update_ip:status code: %s", code)
 	}
 
+	log.Println("New IP updated!")
+	return nil
 }
 
 // PostData post data and invoke DNSPod API
-func (handler *Handler) PostData(url string, content url.Values) (string, error) {
+func (handler *Handler) PostData(ctx context.Context, url string, content url.Values) (string, error) {
 	client := godns.GetHttpClient(handler.Configuration)
 
 	if client == nil {
@@ -253,6 +240,7 @@ func (handler *Handler) PostData(url string, content url.Values) (string, error)
 
 	values := handler.GenerateHeader(content)
 	req, _ := http.NewRequest("POST", handler.API+url, strings.NewReader(values.Encode()))
+	req = req.WithContext(ctx)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("User-Agent", fmt.Sprintf("GoDNS/0.1 (%s)", ""))
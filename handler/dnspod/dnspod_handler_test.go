@@ -0,0 +1,38 @@
+package dnspod
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TimothyYe/godns"
+)
+
+func TestGetSubDomain(t *testing.T) {
+	code := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": {"code": %q, "message": "message"}, "records": [{"id": "16894439", "name": "www", "value": "1.1.1.1"}]}`, code)
+	}))
+	defer server.Close()
+
+	handler := &Handler{}
+	handler.SetConfiguration(&godns.Settings{LoginToken: "token", Api: server.URL})
+
+	if id, ip, err := handler.GetSubDomain(context.Background(), 1, "www", "A"); err != nil || id != "16894439" || ip != "1.1.1.1" {
+		t.Errorf("should get the record, got %s, %s, %v", id, ip, err)
+	}
+
+	// a missing record is not an error
+	code = codeNoRecords
+	if id, _, err := handler.GetSubDomain(context.Background(), 1, "www", "A"); err != nil || id != "" {
+		t.Errorf("record is missing, got %s, %v", id, err)
+	}
+
+	// other failures are retried
+	code = "-1"
+	if _, _, err := handler.GetSubDomain(context.Background(), 1, "www", "A"); err == nil {
+		t.Error("bad token, should be failed")
+	}
+}
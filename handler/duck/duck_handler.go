@@ -1,11 +1,13 @@
 package duck
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"runtime/debug"
-	"time"
+	"net/http"
+	"strings"
 
 	"github.com/TimothyYe/godns"
 )
@@ -31,65 +33,39 @@ func (handler *Handler) SetConfiguration(conf *godns.Settings) {
 	}
 }
 
-// DomainLoop the main logic loop
-func (handler *Handler) DomainLoop(domain *godns.Domain, panicChan chan<- godns.Domain) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered in %v: %v\n", err, debug.Stack())
-			panicChan <- *domain
-		}
-	}()
-
-	var lastIP string
-
-	for {
-		currentIP, err := godns.GetCurrentIP(handler.Configuration)
-
-		if err != nil {
-			log.Println("get_currentIP:", err)
-			continue
-		}
-		log.Println("currentIP is:", currentIP)
-
-		//check against locally cached IP, if no change, skip update
-		if currentIP == lastIP {
-			log.Printf("IP is the same as cached one. Skip update.\n")
-		} else {
-			lastIP = currentIP
-			client := godns.GetHttpClient(handler.Configuration)
+// UpdateRecord updates the record with new IP
+func (handler *Handler) UpdateRecord(ctx context.Context, record *godns.Record, ip string) error {
+	client := godns.GetHttpClient(handler.Configuration)
+	if client == nil {
+		return errors.New("failed to create HTTP client")
+	}
 
-			for _, subDomain := range domain.SubDomains {
-				// update IP with HTTP GET request
-				resp, err := client.Get(fmt.Sprintf(handler.API, subDomain, handler.Configuration.LoginToken, currentIP))
-				if err != nil {
-					// handle error
-					log.Print("Failed to update sub domain:", subDomain)
-					continue
-				}
+	api := handler.API
+	if record.Type == "AAAA" {
+		api = strings.Replace(api, "ip=%s", "ipv6=%s", 1)
+	}
 
-				defer resp.Body.Close()
+	// update IP with HTTP GET request
+	req, err := http.NewRequest("GET", fmt.Sprintf(api, record.SubDomain, handler.Configuration.LoginToken, ip), nil)
+	if err != nil {
+		return err
+	}
 
-				body, err := ioutil.ReadAll(resp.Body)
-				if err != nil || string(body) != "OK" {
-					// handle error
-					log.Print("Failed to update sub domain:", subDomain, err.Error())
-					continue
-				} else {
-					log.Print("IP updated to:", currentIP)
-				}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
 
-				// Send mail notification if notify is enabled
-				if handler.Configuration.Notify.Enabled {
-					log.Print("Sending notification to:", handler.Configuration.Notify.SendTo)
-					if err := godns.SendNotify(handler.Configuration, fmt.Sprintf("%s.%s", subDomain, domain.DomainName), currentIP); err != nil {
-						log.Println("Failed to send notification")
-					}
-				}
-			}
-		}
+	defer resp.Body.Close()
 
-		// Sleep with interval
-		log.Printf("Going to sleep, will start next checking in %d seconds...\r\n", handler.Configuration.Interval)
-		time.Sleep(time.Second * time.Duration(handler.Configuration.Interval))
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
+	if string(body) != "OK" {
+		return fmt.Errorf("failed to update sub domain %s: %s", record.SubDomain, string(body))
+	}
+
+	log.Print("IP updated to:", ip)
+	return nil
 }
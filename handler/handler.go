@@ -10,10 +10,11 @@ import (
 	"github.com/TimothyYe/godns/handler/he"
 )
 
-// IHandler is the interface for all DNS handlers
+// IHandler is the interface for all DNS handlers, handlers only do the record
+// operations, the main logic loop is godns.DomainWorker
 type IHandler interface {
 	SetConfiguration(*godns.Settings)
-	DomainLoop(domain *godns.Domain, panicChan chan<- godns.Domain)
+	godns.Provider
 }
 
 // CreateHandler creates DNS handler by different providers
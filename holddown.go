@@ -0,0 +1,70 @@
+package godns
+
+import (
+	"log"
+	"time"
+)
+
+// candidate is a changed IP waiting for the hold-down to be over
+type candidate struct {
+	ip     string
+	since  time.Time
+	checks int
+}
+
+// holdDown reports whether the current IP of the IP type should be published, following the
+// hold-down policy. The first IP is published right away, a changed one once it is stable.
+// The check is only counted if poll is set, since the triggered ones may come in bursts.
+// The caller must hold the lock.
+func (d *IPDetector) holdDown(policy HoldDown, ipType, currentIP string, now time.Time, poll bool) bool {
+	published := d.addresses[ipType]
+	pending := d.pending[ipType]
+
+	if currentIP == published {
+		if pending != nil {
+			log.Printf("[%s] IP %s reverted to %s after %d check(s), not published\n", ipType, pending.ip, published, pending.checks)
+			delete(d.pending, ipType)
+		}
+		return false
+	}
+	if published == "" || (policy.Checks <= 1 && policy.Duration <= 0) {
+		delete(d.pending, ipType)
+		return true
+	}
+
+	if pending == nil || pending.ip != currentIP {
+		if pending != nil {
+			log.Printf("[%s] IP %s changed to %s after %d check(s), not published\n", ipType, pending.ip, currentIP, pending.checks)
+		}
+		pending = &candidate{ip: currentIP, since: now}
+		d.pending[ipType] = pending
+	}
+	if poll {
+		pending.checks++
+	}
+
+	held := now.Sub(pending.since)
+	if pending.checks >= policy.Checks && held >= time.Duration(policy.Duration)*time.Second {
+		delete(d.pending, ipType)
+		return true
+	}
+
+	log.Printf("[%s] IP changed to %s, waiting for it to be stable (%d/%d checks, %.0f/%d seconds)\n",
+		ipType, currentIP, pending.checks, policy.Checks, held.Seconds(), policy.Duration)
+	return false
+}
+
+// holdDownWait returns how long until the duration of the first pending IP is over, zero if none
+func (d *IPDetector) holdDownWait(policy HoldDown, now time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var wait time.Duration
+	for _, pending := range d.pending {
+		left := pending.since.Add(time.Duration(policy.Duration) * time.Second).Sub(now)
+		if left > 0 && (wait == 0 || left < wait) {
+			wait = left
+		}
+	}
+	return wait
+}
@@ -0,0 +1,85 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPDetectorHoldDown(t *testing.T) {
+	ip := "1.1.1.1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, ip)
+	}))
+	defer server.Close()
+
+	detector := NewIPDetector(&Settings{IPUrl: server.URL, Interval: 300, HoldDown: HoldDown{Checks: 3}})
+	addresses := detector.Subscribe()
+
+	// the first IP is published right away
+	detector.Detect(context.Background())
+	if latest := <-addresses; latest[IPV4] != "1.1.1.1" {
+		t.Fatalf("first IP should be published, got %v", latest)
+	}
+
+	// a change reverted within the hold-down is not published
+	ip = "2.2.2.2"
+	detector.Detect(context.Background())
+	detector.Detect(context.Background())
+	ip = "1.1.1.1"
+	detector.Detect(context.Background())
+	select {
+	case latest := <-addresses:
+		t.Errorf("reverted IP should not be published, got %v", latest)
+	default:
+	}
+
+	// the triggered checks are not counted
+	ip = "2.2.2.2"
+	for i := 0; i < 5; i++ {
+		detector.detect(context.Background(), false)
+	}
+	select {
+	case latest := <-addresses:
+		t.Errorf("triggered checks should not publish the IP, got %v", latest)
+	default:
+	}
+
+	// a stable change is published after the checks
+	for i := 0; i < 3; i++ {
+		detector.Detect(context.Background())
+	}
+	if latest := <-addresses; latest[IPV4] != "2.2.2.2" {
+		t.Errorf("stable IP should be published, got %v", latest)
+	}
+}
+
+func TestHoldDownDuration(t *testing.T) {
+	detector := NewIPDetector(&Settings{})
+	policy := HoldDown{Duration: 600}
+	now := time.Now()
+
+	if !detector.holdDown(policy, IPV4, "1.1.1.1", now, true) {
+		t.Fatal("first IP should be published")
+	}
+	detector.addresses[IPV4] = "1.1.1.1"
+
+	if detector.holdDown(policy, IPV4, "2.2.2.2", now, true) {
+		t.Error("changed IP should wait for the duration")
+	}
+	if wait := detector.holdDownWait(policy, now.Add(time.Minute)); wait != 9*time.Minute {
+		t.Errorf("should wait for the rest of the duration, got %v", wait)
+	}
+	if detector.holdDown(policy, IPV4, "2.2.2.2", now.Add(5*time.Minute), true) {
+		t.Error("changed IP should wait for the duration")
+	}
+	if !detector.holdDown(policy, IPV4, "2.2.2.2", now.Add(10*time.Minute), true) {
+		t.Error("changed IP should be published after the duration")
+	}
+	if wait := detector.holdDownWait(policy, now.Add(10*time.Minute)); wait != 0 {
+		t.Errorf("nothing should be pending, got %v", wait)
+	}
+}
@@ -0,0 +1,120 @@
+package godns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DefaultPrefixLength is the length of the delegated IPv6 prefix
+const DefaultPrefixLength = 64
+
+// GetDelegatedPrefix returns the IPv6 prefix of the prefix interface of the domain,
+// e.g. 2001:db8:1:2::/64 when the LAN interface has 2001:db8:1:2::1/64
+func GetDelegatedPrefix(configuration *Settings, domain *Domain) (*net.IPNet, error) {
+	ip, err := GetIPFromInterfaceSource(WithIPType(configuration, IPV6), prefixSource(domain))
+	if err != nil {
+		return nil, err
+	}
+
+	length := domain.PrefixLength
+	if length == 0 {
+		length = DefaultPrefixLength
+	}
+	mask := net.CIDRMask(length, 128)
+	return &net.IPNet{IP: net.ParseIP(ip).Mask(mask), Mask: mask}, nil
+}
+
+// prefixSource returns the source of the address of the prefix interface. Only global addresses
+// are used, since the LAN usually has a unique local prefix too.
+func prefixSource(domain *Domain) *IPSource {
+	return &IPSource{Type: SourceInterface, Interface: domain.PrefixInterface, Allow: []string{"2000::/3"}}
+}
+
+// HostIP returns the IPv6 address of the host in the prefix
+func HostIP(prefix *net.IPNet, host *Host) (string, error) {
+	id, err := interfaceID(host)
+	if err != nil {
+		return "", err
+	}
+	if host.MAC != "" {
+		if ones, _ := prefix.Mask.Size(); ones > 64 {
+			return "", errors.New("EUI-64 needs a prefix of 64 bits or shorter")
+		}
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = prefix.IP[i]&prefix.Mask[i] | id[i]&^prefix.Mask[i]
+	}
+	return ip.String(), nil
+}
+
+// interfaceID returns the interface ID of the host, the one configured like ::1234,
+// or the modified EUI-64 of the MAC address
+func interfaceID(host *Host) (net.IP, error) {
+	if host.InterfaceID != "" {
+		id := net.ParseIP(host.InterfaceID)
+		if id == nil || id.To4() != nil {
+			return nil, fmt.Errorf("invalid interface ID: %s", host.InterfaceID)
+		}
+		return id, nil
+	}
+
+	mac, err := net.ParseMAC(host.MAC)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC address: %s", host.MAC)
+	}
+	id := make(net.IP, net.IPv6len)
+	copy(id[8:], []byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]})
+	return id, nil
+}
+
+// hostRecords returns the IP of each host of the domain, in the current delegated prefix
+func hostRecords(configuration *Settings, domain *Domain) (map[string]string, error) {
+	prefix, err := GetDelegatedPrefix(configuration, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]string, len(domain.Hosts))
+	for i := range domain.Hosts {
+		ip, err := HostIP(prefix, &domain.Hosts[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", domain.Hosts[i].SubDomain, err.Error())
+		}
+		ips[domain.Hosts[i].SubDomain] = ip
+	}
+	return ips, nil
+}
+
+// checkHosts checks the hosts of the domain
+func checkHosts(config *Settings, domain *Domain) error {
+	if len(domain.Hosts) == 0 {
+		return nil
+	}
+
+	if !IsIPv6(config) && !IsDualStack(config) {
+		return errors.New("hosts need ip_type IPv6 or DualStack")
+	}
+	if domain.PrefixInterface == "" {
+		return errors.New("prefix_interface cannot be empty for hosts")
+	}
+	if domain.PrefixLength < 0 || domain.PrefixLength > 128 {
+		return errors.New("prefix_length should be between 0 and 128")
+	}
+
+	for i := range domain.Hosts {
+		host := &domain.Hosts[i]
+		if host.SubDomain == "" {
+			return errors.New("sub_domain of host cannot be empty")
+		}
+		if (host.InterfaceID == "") == (host.MAC == "") {
+			return fmt.Errorf("host %s: either interface_id or mac is required", host.SubDomain)
+		}
+		if _, err := interfaceID(host); err != nil {
+			return fmt.Errorf("host %s: %s", host.SubDomain, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package godns
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestHostIP(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1:2::/64")
+
+	if ip, err := HostIP(prefix, &Host{InterfaceID: "::1234"}); err != nil || ip != "2001:db8:1:2::1234" {
+		t.Errorf("should combine the prefix and the interface ID, got %s, %v", ip, err)
+	}
+	if ip, err := HostIP(prefix, &Host{MAC: "00:11:22:33:44:55"}); err != nil || ip != "2001:db8:1:2:211:22ff:fe33:4455" {
+		t.Errorf("should combine the prefix and the EUI-64 of the MAC, got %s, %v", ip, err)
+	}
+
+	// the bits of the interface ID inside the prefix are ignored
+	_, prefix, _ = net.ParseCIDR("2001:db8:1:2300::/56")
+	if ip, err := HostIP(prefix, &Host{InterfaceID: "ffff:ffff:ffff:ff45::1"}); err != nil || ip != "2001:db8:1:2345::1" {
+		t.Errorf("should use the interface ID after the prefix, got %s, %v", ip, err)
+	}
+
+	_, prefix, _ = net.ParseCIDR("2001:db8:1:2::/80")
+	if ip, err := HostIP(prefix, &Host{MAC: "00:11:22:33:44:55"}); err == nil {
+		t.Errorf("EUI-64 should fail with a prefix longer than 64 bits, got %s", ip)
+	}
+	if ip, err := HostIP(prefix, &Host{InterfaceID: "1.2.3.4"}); err == nil {
+		t.Errorf("IPv4 interface ID should fail, got %s", ip)
+	}
+}
+
+func TestCheckHosts(t *testing.T) {
+	domain := Domain{
+		DomainName:      "example.com",
+		Hosts:           []Host{{SubDomain: "nas", InterfaceID: "::1234"}, {SubDomain: "tv", MAC: "00:11:22:33:44:55"}},
+		PrefixInterface: "br-lan",
+	}
+	conf := &Settings{Provider: "DNSPod", LoginToken: "aaa", IPType: DUALSTACK, IPV6Url: "http://ipv6.example.com", Domains: []Domain{domain}}
+	if err := CheckSettings(conf); err != nil {
+		t.Error("setting with hosts should be passed:", err)
+	}
+
+	conf.IPType = IPV4
+	if err := CheckSettings(conf); err == nil {
+		t.Error("hosts without IPv6, should be failed")
+	}
+
+	conf.IPType = IPV6
+	conf.Domains[0].Hosts = []Host{{SubDomain: "nas", MAC: "not a mac"}}
+	if err := CheckSettings(conf); err == nil {
+		t.Error("host with invalid MAC, should be failed")
+	}
+
+	conf.Domains[0].Hosts = []Host{{SubDomain: "nas", InterfaceID: "::1"}}
+	conf.Domains[0].PrefixInterface = ""
+	if err := CheckSettings(conf); err == nil {
+		t.Error("hosts without prefix_interface, should be failed")
+	}
+}
+
+func TestPrefixSource(t *testing.T) {
+	conf := &Settings{IPType: IPV6}
+	ips := []net.IP{net.ParseIP("fd00:1:2:3::1"), net.ParseIP("2001:db8:1:2::1")}
+	ip, err := selectIP(conf, prefixSource(&Domain{PrefixInterface: "br-lan"}), ips, nil)
+	if err != nil || ip.String() != "2001:db8:1:2::1" {
+		t.Errorf("the global address should be used for the prefix, got %v, %v", ip, err)
+	}
+}
+
+func TestSyncDomainWithHosts(t *testing.T) {
+	conf := &Settings{IPType: IPV6}
+	domain := &Domain{
+		DomainName: "example.com",
+		SubDomains: []string{"www"},
+		Hosts:      []Host{{SubDomain: "nas", InterfaceID: "::1234"}, {SubDomain: "tv", InterfaceID: "::5678"}},
+		// the documentation addresses are not public
+		AllowPrivate: []string{"www", "nas", "tv"},
+	}
+	provider := &fakeLister{
+		fakeProvider: fakeProvider{updated: map[string]string{}},
+		records: []*Record{
+			{ID: "1", Domain: "example.com", SubDomain: "www", Type: "AAAA", IP: "2001:db8::1"},
+			{ID: "2", Domain: "example.com", SubDomain: "nas", Type: "AAAA", IP: "2001:db8:1:1::1234"},
+			{ID: "3", Domain: "example.com", SubDomain: "tv", Type: "AAAA", IP: "2001:db8:1:2::5678"},
+		},
+	}
+
+	hosts := map[string]string{"nas": "2001:db8:1:2::1234", "tv": "2001:db8:1:2::5678"}
+	if err := syncDomain(context.Background(), provider, conf, domain, "2001:db8::2", hosts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if provider.updated["www"] != "2001:db8::2" {
+		t.Error("www should be updated to the current IP")
+	}
+	if provider.updated["nas"] != "2001:db8:1:2::1234" {
+		t.Error("nas should be updated to the IP in the new prefix")
+	}
+	if _, ok := provider.updated["tv"]; ok {
+		t.Error("tv already points to the IP in the prefix, should not be updated")
+	}
+}
@@ -0,0 +1,173 @@
+package godns
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// IPv6 address flags in /proc/net/if_inet6
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDADFailed  = 0x08
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// GetIPFromInterfaceSource gets the IP address from the interfaces matching the source
+func GetIPFromInterfaceSource(configuration *Settings, source *IPSource) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Println("can't get network devices:", err)
+		return "", err
+	}
+
+	var ips []net.IP
+	matched := false
+	for _, iface := range ifaces {
+		if ok, _ := path.Match(source.Interface, iface.Name); !ok {
+			continue
+		}
+		matched = true
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Println("can't get address from "+iface.Name+":", err)
+			continue
+		}
+
+		for _, addr := range addrs {
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ips = append(ips, v.IP)
+			case *net.IPAddr:
+				ips = append(ips, v.IP)
+			}
+		}
+	}
+
+	if !matched {
+		log.Println("can't get network device " + source.Interface)
+		return "", errors.New("no network device matches " + source.Interface)
+	}
+
+	// flags are only known on Linux
+	var flags map[string]int
+	if IsIPv6(configuration) {
+		flags = ipv6AddressFlags()
+	}
+
+	ip, err := selectIP(configuration, source, ips, flags)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// selectIP selects the address of the source from the addresses of the interfaces. The global
+// unicast addresses of the configured IP type are filtered by the allow and deny CIDR lists, and
+// the one at the index is returned. For IPv6, temporary and deprecated addresses are skipped, and
+// stable or EUI-64 addresses come first.
+func selectIP(configuration *Settings, source *IPSource, ips []net.IP, flags map[string]int) (net.IP, error) {
+	allow, err := parseCIDRs(source.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(source.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	var stable, unknown []net.IP
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() || (ip.To4() == nil) != IsIPv6(configuration) {
+			continue
+		}
+		if (len(allow) > 0 && !containsIP(allow, ip)) || containsIP(deny, ip) {
+			continue
+		}
+
+		if !IsIPv6(configuration) {
+			stable = append(stable, ip)
+			continue
+		}
+
+		f, ok := flags[ip.String()]
+		if f&(ifaFlagTemporary|ifaFlagDeprecated|ifaFlagTentative|ifaFlagDADFailed) != 0 {
+			continue
+		}
+		// without the flags, only an EUI-64 address is known to be stable
+		if ok || isEUI64(ip) {
+			stable = append(stable, ip)
+		} else {
+			unknown = append(unknown, ip)
+		}
+	}
+
+	candidates := append(stable, unknown...)
+	if source.Index < 0 || source.Index >= len(candidates) {
+		return nil, errors.New("can't get a vaild address from " + source.Interface)
+	}
+	return candidates[source.Index], nil
+}
+
+// isEUI64 reports whether the interface ID of the IPv6 address is derived from a MAC address
+func isEUI64(ip net.IP) bool {
+	return len(ip) == net.IPv6len && ip[11] == 0xff && ip[12] == 0xfe
+}
+
+// ipv6AddressFlags reads the flags of the IPv6 addresses from /proc/net/if_inet6, the address is
+// followed by the interface index, prefix length, scope, flags and name, in hex
+func ipv6AddressFlags() map[string]int {
+	flags := map[string]int{}
+	data, err := ioutil.ReadFile("/proc/net/if_inet6")
+	if err != nil {
+		return flags
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		ip, err := hex.DecodeString(fields[0])
+		if err != nil || len(ip) != net.IPv6len {
+			continue
+		}
+		f, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		flags[net.IP(ip).String()] = int(f)
+	}
+	return flags
+}
+
+// parseCIDRs parses the CIDR list
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether any of the networks contains the IP
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package godns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSelectIP(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("192.168.1.2"),
+		net.ParseIP("100.64.1.2"),
+		net.ParseIP("203.0.113.5"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("2001:db8::1234:5678:9abc:def0"),
+		net.ParseIP("2001:db8::aaaa"),
+		net.ParseIP("2001:db8::211:22ff:fe33:4455"),
+		net.ParseIP("2001:db8::dead"),
+	}
+
+	tests := []struct {
+		conf   *Settings
+		source IPSource
+		flags  map[string]int
+		ip     string
+	}{
+		{&Settings{}, IPSource{}, nil, "192.168.1.2"},
+		{&Settings{}, IPSource{Index: 2}, nil, "203.0.113.5"},
+		{&Settings{}, IPSource{Deny: []string{"192.168.0.0/16", "100.64.0.0/10"}}, nil, "203.0.113.5"},
+		{&Settings{}, IPSource{Allow: []string{"100.64.0.0/10"}}, nil, "100.64.1.2"},
+		// without flags, EUI-64 comes first
+		{&Settings{IPType: IPV6}, IPSource{}, nil, "2001:db8::211:22ff:fe33:4455"},
+		{&Settings{IPType: IPV6}, IPSource{Index: 1}, nil, "2001:db8::1234:5678:9abc:def0"},
+		// temporary and deprecated addresses are skipped
+		{&Settings{IPType: IPV6}, IPSource{}, map[string]int{
+			"2001:db8::1234:5678:9abc:def0": ifaFlagTemporary,
+			"2001:db8::aaaa":                0x80,
+			"2001:db8::211:22ff:fe33:4455":  ifaFlagDeprecated,
+			"2001:db8::dead":                0,
+		}, "2001:db8::aaaa"},
+		{&Settings{IPType: IPV6}, IPSource{Deny: []string{"2001:db8::211:22ff:fe33:4455/128"}}, nil, "2001:db8::1234:5678:9abc:def0"},
+	}
+
+	for _, test := range tests {
+		ip, err := selectIP(test.conf, &test.source, ips, test.flags)
+		if err != nil || ip.String() != test.ip {
+			t.Errorf("should select %s with %+v, got %s, %v", test.ip, test.source, ip, err)
+		}
+	}
+
+	if ip, err := selectIP(&Settings{}, &IPSource{Index: 3}, ips, nil); err == nil {
+		t.Errorf("index out of range should fail, got %s", ip)
+	}
+	if ip, err := selectIP(&Settings{}, &IPSource{Allow: []string{"10.0.0.0/8"}}, ips, nil); err == nil {
+		t.Errorf("no allowed address should fail, got %s", ip)
+	}
+}
+
+func TestGetIPFromInterfaceSource(t *testing.T) {
+	if ip, err := GetIPFromInterfaceSource(&Settings{}, &IPSource{Interface: "no-such-device*"}); err == nil {
+		t.Errorf("unknown interface should fail, got %s", ip)
+	}
+
+	// loopback has no global unicast address
+	if ip, err := GetIPFromInterfaceSource(&Settings{}, &IPSource{Interface: "l?"}); err == nil {
+		t.Errorf("loopback should fail, got %s", ip)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+package godns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchAddresses subscribes to the netlink address and route events until the context is done. It calls
+// changed with the name of the interface on each RTM_NEWADDR or RTM_DELADDR, and check with the reason
+// on each RTM_NEWROUTE or RTM_DELROUTE of a default route, or when events were lost.
+func watchAddresses(ctx context.Context, changed func(name string), check func(reason string)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// the multicast groups are a bit mask of 1 << (RTNLGRP_* - 1)
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1<<(syscall.RTNLGRP_IPV4_IFADDR-1) | 1<<(syscall.RTNLGRP_IPV6_IFADDR-1) |
+			1<<(syscall.RTNLGRP_IPV4_ROUTE-1) | 1<<(syscall.RTNLGRP_IPV6_ROUTE-1),
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return err
+	}
+
+	// wake up every second to check the context
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.ENOBUFS {
+			// the socket buffer overflowed, the lost events may be of a watched interface
+			check("Netlink events were lost")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		names, route := parseAddressMessages(buf[:n])
+		for _, name := range names {
+			changed(name)
+		}
+		if route {
+			check("Default route changed")
+		}
+	}
+	return ctx.Err()
+}
+
+// parseAddressMessages returns the names of the interfaces in the RTM_NEWADDR and RTM_DELADDR
+// messages, and whether there is a RTM_NEWROUTE or RTM_DELROUTE message of a default route.
+// The IFA_LABEL attribute is used if there is one, since the interface may be gone already.
+func parseAddressMessages(buf []byte) ([]string, bool) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, false
+	}
+
+	var names []string
+	route := false
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type == syscall.RTM_NEWROUTE || msg.Header.Type == syscall.RTM_DELROUTE {
+			// e.g. failover to another WAN, which doesn't change the addresses
+			if len(msg.Data) >= syscall.SizeofRtMsg && (*syscall.RtMsg)(unsafe.Pointer(&msg.Data[0])).Dst_len == 0 {
+				route = true
+			}
+			continue
+		}
+		if msg.Header.Type != syscall.RTM_NEWADDR && msg.Header.Type != syscall.RTM_DELADDR {
+			continue
+		}
+		if len(msg.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		name := ""
+		if attrs, err := syscall.ParseNetlinkRouteAttr(msg); err == nil {
+			for _, attr := range attrs {
+				if attr.Attr.Type == syscall.IFA_LABEL {
+					name = strings.TrimRight(string(attr.Value), "\x00")
+				}
+			}
+		}
+		if name == "" {
+			ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&msg.Data[0]))
+			iface, err := net.InterfaceByIndex(int(ifa.Index))
+			if err != nil {
+				continue
+			}
+			name = iface.Name
+		}
+		names = append(names, name)
+	}
+	return names, route
+}
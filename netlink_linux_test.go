@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package godns
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// addressMessage builds a netlink address message with the interface index and label
+func addressMessage(msgType uint16, index uint32, label string) []byte {
+	attrLen := syscall.SizeofRtAttr + len(label) + 1
+	size := syscall.NLMSG_HDRLEN + syscall.SizeofIfAddrmsg + (attrLen+syscall.RTA_ALIGNTO-1)&^(syscall.RTA_ALIGNTO-1)
+	buf := make([]byte, size)
+
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&buf[0])) = syscall.NlMsghdr{Len: uint32(size), Type: msgType}
+	*(*syscall.IfAddrmsg)(unsafe.Pointer(&buf[syscall.NLMSG_HDRLEN])) = syscall.IfAddrmsg{Family: syscall.AF_INET, Index: index}
+	if label != "" {
+		attr := buf[syscall.NLMSG_HDRLEN+syscall.SizeofIfAddrmsg:]
+		*(*syscall.RtAttr)(unsafe.Pointer(&attr[0])) = syscall.RtAttr{Len: uint16(attrLen), Type: syscall.IFA_LABEL}
+		copy(attr[syscall.SizeofRtAttr:], label)
+	}
+	return buf
+}
+
+// routeMessage builds a netlink route message with the destination length
+func routeMessage(msgType uint16, dstLen uint8) []byte {
+	size := syscall.NLMSG_HDRLEN + syscall.SizeofRtMsg
+	buf := make([]byte, size)
+
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&buf[0])) = syscall.NlMsghdr{Len: uint32(size), Type: msgType}
+	*(*syscall.RtMsg)(unsafe.Pointer(&buf[syscall.NLMSG_HDRLEN])) = syscall.RtMsg{Family: syscall.AF_INET, Dst_len: dstLen}
+	return buf
+}
+
+func TestParseAddressMessages(t *testing.T) {
+	var buf []byte
+	buf = append(buf, addressMessage(syscall.RTM_NEWADDR, 100, "ppp0")...)
+	buf = append(buf, addressMessage(syscall.RTM_NEWLINK, 100, "ppp0")...)
+	buf = append(buf, addressMessage(syscall.RTM_DELADDR, 101, "eth0")...)
+	// without label, the name is got from the index
+	buf = append(buf, addressMessage(syscall.RTM_NEWADDR, 1, "")...)
+
+	// a route which is not the default one
+	buf = append(buf, routeMessage(syscall.RTM_NEWROUTE, 24)...)
+
+	names, route := parseAddressMessages(buf)
+	if len(names) != 3 || names[0] != "ppp0" || names[1] != "eth0" || names[2] != "lo" {
+		t.Errorf("should get the interfaces of the address messages, got %v", names)
+	}
+	if route {
+		t.Error("should not report a default route change")
+	}
+
+	for _, msgType := range []uint16{syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE} {
+		if _, route := parseAddressMessages(routeMessage(msgType, 0)); !route {
+			t.Errorf("should report a default route change of message type %d", msgType)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package godns
+
+import (
+	"context"
+	"errors"
+)
+
+// watchAddresses is only supported on Linux
+func watchAddresses(ctx context.Context, changed func(name string), check func(reason string)) error {
+	return errors.New("watching address changes is only supported on Linux")
+}
@@ -0,0 +1,141 @@
+package godns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatText is a response with only the IP in it
+	FormatText = "text"
+	// FormatJSON is a JSON response with the IP in a field
+	FormatJSON = "json"
+	// FormatTrace is a key=value response like Cloudflare's cdn-cgi/trace
+	FormatTrace = "trace"
+	// FormatRegex is a response with the IP matched by a regex
+	FormatRegex = "regex"
+)
+
+// responseFormat returns the response format of the source, text if not set
+func responseFormat(source *IPSource) string {
+	if source.Format == "" {
+		return FormatText
+	}
+	return strings.ToLower(source.Format)
+}
+
+// ParseIPResponse extracts the IP from the response of the source, following its format
+func ParseIPResponse(source *IPSource, body []byte) (string, error) {
+	switch responseFormat(source) {
+	case FormatText:
+		return strings.TrimSpace(string(body)), nil
+	case FormatJSON:
+		return parseJSON(body, source.Field)
+	case FormatTrace:
+		return parseTrace(body, source.Field)
+	case FormatRegex:
+		return parseRegex(body, source.Regex)
+	}
+	return "", fmt.Errorf("unknown response format: %s", source.Format)
+}
+
+// parseJSON gets the string at the dot separated path, e.g. data.ip or addresses.0
+func parseJSON(body []byte, path string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", err
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("no field %s in response", path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("no field %s in response", path)
+		}
+	}
+
+	ip, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("no field %s in response", path)
+	}
+	return ip, nil
+}
+
+// parseTrace gets the value of the key from key=value lines, the ip key if not set
+func parseTrace(body []byte, key string) (string, error) {
+	if key == "" {
+		key = "ip"
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 && parts[0] == key {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("no key %s in response", key)
+}
+
+// parseRegex gets the first submatch of the regex, or the whole match if it has no group
+func parseRegex(body []byte, pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	match := re.FindSubmatch(body)
+	if match == nil {
+		return "", errors.New("no IP matched in response")
+	}
+	if len(match) > 1 {
+		return string(match[1]), nil
+	}
+	return string(match[0]), nil
+}
+
+// ValidateIP checks that the IP is valid and of the configured IP type, and returns it
+// in the canonical form
+func ValidateIP(configuration *Settings, s string) (string, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		if len(s) > 64 {
+			s = s[:64] + "..."
+		}
+		return "", fmt.Errorf("invalid IP: %q", s)
+	}
+
+	if (ip.To4() == nil) != IsIPv6(configuration) {
+		return "", fmt.Errorf("%s is not an %s address", ip, GetIPTypes(configuration)[0])
+	}
+	return ip.String(), nil
+}
+
+// checkResponseFormat checks the response format of the source
+func checkResponseFormat(source *IPSource) error {
+	switch responseFormat(source) {
+	case FormatText, FormatTrace:
+	case FormatJSON:
+		if source.Field == "" {
+			return errors.New("field cannot be empty for json format")
+		}
+	case FormatRegex:
+		if _, err := regexp.Compile(source.Regex); err != nil || source.Regex == "" {
+			return errors.New("regex is invalid")
+		}
+	default:
+		return fmt.Errorf("unknown format %s", source.Format)
+	}
+	return nil
+}
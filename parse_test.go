@@ -0,0 +1,91 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIPResponse(t *testing.T) {
+	tests := []struct {
+		source IPSource
+		body   string
+		ip     string
+	}{
+		{IPSource{}, " 1.1.1.1\r\n", "1.1.1.1"},
+		{IPSource{Format: "json", Field: "ip"}, `{"ip": "1.1.1.1"}`, "1.1.1.1"},
+		{IPSource{Format: "JSON", Field: "data.addresses.1"}, `{"data": {"addresses": ["2.2.2.2", "1.1.1.1"]}}`, "1.1.1.1"},
+		{IPSource{Format: "trace"}, "fl=123\nh=example.com\nip=1.1.1.1\nts=1600000000\n", "1.1.1.1"},
+		{IPSource{Format: "regex", Regex: `Current IP Address: ([0-9.]+)`}, "<body>Current IP Address: 1.1.1.1</body>", "1.1.1.1"},
+		{IPSource{Format: "regex", Regex: `\d+\.\d+\.\d+\.\d+`}, "Your IP is 1.1.1.1.", "1.1.1.1"},
+	}
+
+	for _, test := range tests {
+		ip, err := ParseIPResponse(&test.source, []byte(test.body))
+		if err != nil || ip != test.ip {
+			t.Errorf("%s format should get %s from %q, got %s, %v", test.source.Format, test.ip, test.body, ip, err)
+		}
+	}
+
+	failures := []struct {
+		source IPSource
+		body   string
+	}{
+		{IPSource{Format: "json", Field: "ip"}, `<html>502 Bad Gateway</html>`},
+		{IPSource{Format: "json", Field: "ip"}, `{"ip": 1}`},
+		{IPSource{Format: "json", Field: "data.ip"}, `{"data": "1.1.1.1"}`},
+		{IPSource{Format: "trace"}, "fl=123\n"},
+		{IPSource{Format: "regex", Regex: `ip=(\S+)`}, "no ip"},
+		{IPSource{Format: "xml"}, "<ip>1.1.1.1</ip>"},
+	}
+
+	for _, test := range failures {
+		if ip, err := ParseIPResponse(&test.source, []byte(test.body)); err == nil {
+			t.Errorf("%s format should fail on %q, got %s", test.source.Format, test.body, ip)
+		}
+	}
+}
+
+func TestValidateIP(t *testing.T) {
+	if ip, err := ValidateIP(&Settings{}, "1.1.1.1"); err != nil || ip != "1.1.1.1" {
+		t.Errorf("IPv4 address should be valid, got %s, %v", ip, err)
+	}
+	if ip, err := ValidateIP(&Settings{IPType: IPV6}, "2001:DB8::0001"); err != nil || ip != "2001:db8::1" {
+		t.Errorf("IPv6 address should be valid and canonical, got %s, %v", ip, err)
+	}
+	if _, err := ValidateIP(&Settings{}, "2001:db8::1"); err == nil {
+		t.Error("IPv6 address should be invalid for IPv4")
+	}
+	if _, err := ValidateIP(&Settings{IPType: IPV6}, "1.1.1.1"); err == nil {
+		t.Error("IPv4 address should be invalid for IPv6")
+	}
+	if _, err := ValidateIP(&Settings{}, "<html><title>Login</title></html>"); err == nil {
+		t.Error("captive portal page should be invalid")
+	}
+}
+
+func TestGetIPOnline(t *testing.T) {
+	status, body := http.StatusOK, "1.1.1.1\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	conf := &Settings{IPUrl: server.URL}
+	if ip, err := GetIPOnline(context.Background(), conf); err != nil || ip != "1.1.1.1" {
+		t.Errorf("should get the IP, got %s, %v", ip, err)
+	}
+
+	status, body = http.StatusBadGateway, "1.1.1.1"
+	if ip, err := GetIPOnline(context.Background(), conf); err == nil {
+		t.Errorf("should fail on error status, got %s", ip)
+	}
+
+	status, body = http.StatusOK, "<html>Please login</html>"
+	if ip, err := GetIPOnline(context.Background(), conf); err == nil {
+		t.Errorf("should fail on captive portal page, got %s", ip)
+	}
+}
@@ -0,0 +1,415 @@
+package godns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Record is a DNS record managed by GoDNS
+type Record struct {
+	// ID is the record ID at the provider, empty if the provider doesn't have one
+	ID string
+	// ZoneID is the zone (domain) ID at the provider, empty if the provider doesn't have one
+	ZoneID    string
+	Domain    string
+	SubDomain string
+	// Type is the record type, A or AAAA
+	Type string
+	// IP is the current content of the record, empty if unknown
+	IP string
+	// Options to set when the record is created or updated, nil if none
+	Options *RecordOptions
+}
+
+// Name returns the full domain name of the record
+func (r *Record) Name() string {
+	return fmt.Sprintf("%s.%s", r.SubDomain, r.Domain)
+}
+
+// Provider is the record operations a DNS provider implements,
+// the IP checking, caching and notification are done by DomainWorker
+type Provider interface {
+	// UpdateRecord points the record to the new IP
+	UpdateRecord(ctx context.Context, record *Record, ip string) error
+}
+
+// RecordLister is implemented by providers which are able to read the records back,
+// so that records already pointing to the current IP are not updated again
+type RecordLister interface {
+	// GetRecords returns the records of the configured sub domains with the specific type,
+	// sub domains without a record are left out
+	GetRecords(ctx context.Context, domain *Domain, recordType string) ([]*Record, error)
+}
+
+// RecordCreator is implemented by providers which are able to create missing records
+type RecordCreator interface {
+	// CreateRecord creates the record pointing to the IP, and sets the ID of the record
+	CreateRecord(ctx context.Context, record *Record, ip string) error
+}
+
+// Verifier is implemented by providers which are able to check their credentials,
+// so that an invalid or expired one is reported when the provider is created
+type Verifier interface {
+	// Verify checks the credentials with the provider
+	Verify(ctx context.Context) error
+}
+
+// DomainWorker keeps the records of a domain in sync with the IP found by the detector,
+// by driving the provider. Its provider and settings can be replaced while running.
+type DomainWorker struct {
+	mu            sync.Mutex
+	provider      Provider
+	configuration *Settings
+	domain        Domain
+	resync        bool
+	store         *StateStore
+
+	// changed wakes up the main logic loop after Update
+	changed chan struct{}
+}
+
+// NewDomainWorker creates a worker for the domain, the IP type is taken from the configuration.
+// The published records are kept in the store, which can be nil.
+func NewDomainWorker(provider Provider, configuration *Settings, domain *Domain, store *StateStore) *DomainWorker {
+	return &DomainWorker{
+		provider:      provider,
+		configuration: configuration,
+		domain:        *domain,
+		store:         store,
+		changed:       make(chan struct{}, 1),
+	}
+}
+
+// Domain returns the domain the worker keeps in sync
+func (w *DomainWorker) Domain() Domain {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.domain
+}
+
+// Update replaces the provider, settings and domain of the worker in place. The cached IP is
+// dropped if the provider or the sub domains changed, so that all the records are checked again.
+func (w *DomainWorker) Update(provider Provider, configuration *Settings, domain *Domain) {
+	w.mu.Lock()
+	if provider != w.provider || !sameSubDomains(domain, &w.domain) {
+		w.resync = true
+	}
+	w.provider = provider
+	w.configuration = configuration
+	w.domain = *domain
+	w.mu.Unlock()
+
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// current returns the provider, settings and domain in use, and whether the cached IP is outdated
+func (w *DomainWorker) current() (Provider, *Settings, Domain, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	resync := w.resync
+	w.resync = false
+	return w.provider, w.configuration, w.domain, resync
+}
+
+// Run the main logic loop, until the context is done. Panics are left to the Supervisor.
+func (w *DomainWorker) Run(ctx context.Context, detector *IPDetector) {
+	addresses := detector.Subscribe()
+	defer detector.Unsubscribe(addresses)
+
+	_, configuration, _, _ := w.current()
+	ipType := IPV4
+	if IsIPv6(configuration) {
+		ipType = IPV6
+	}
+
+	// the ticker recomputes the IPs of the hosts and picks up a changed interval, the records are
+	// synced again when the IPs change, and failed updates are retried sooner following the retry policy
+	interval := configuration.Interval
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer func() { ticker.Stop() }()
+
+	backoff := NewBackoff(configuration.Retry.Provider)
+	var retry <-chan time.Time
+
+	// lastState is the current IP and the IPs of the hosts, once all of them are published
+	var currentIP, lastState string
+	for {
+		select {
+		case latest := <-addresses:
+			currentIP = latest[ipType]
+		case <-ticker.C:
+		case <-retry:
+		case <-w.changed:
+		case <-ctx.Done():
+			return
+		}
+		retry = nil
+
+		provider, configuration, domain, resync := w.current()
+		if resync {
+			lastState = ""
+		}
+		if configuration.Interval != interval {
+			interval = configuration.Interval
+			ticker.Stop()
+			ticker = time.NewTicker(time.Second * time.Duration(interval))
+		}
+
+		if currentIP == "" {
+			continue
+		}
+
+		// the hosts follow the delegated prefix, which may change without the current IP
+		var hosts map[string]string
+		var err error
+		if ipType == IPV6 && len(domain.Hosts) > 0 {
+			if hosts, err = hostRecords(configuration, &domain); err != nil {
+				log.Printf("[%s] Failed to get the delegated prefix of %s: %v\n", ipType, domain.DomainName, err)
+			}
+		}
+		state := currentIP + " " + fmt.Sprint(hosts)
+
+		//check against locally cached IP, if no change, skip update
+		if err == nil && state == lastState {
+			continue
+		}
+
+		if syncErr := syncDomain(ctx, provider, configuration, &domain, currentIP, hosts, w.store); syncErr != nil {
+			err = syncErr
+		}
+		if err != nil {
+			delay := backoff.Next()
+			log.Printf("[%s] Failed to update domain %s: %v, will retry in %.0f seconds\n", ipType, domain.DomainName, err, delay.Seconds())
+			retry = time.After(delay)
+		} else {
+			// only cache the IP once all records are updated, so failed ones are retried
+			lastState = state
+			backoff.Reset()
+		}
+	}
+}
+
+// sameSubDomains reports whether two domains have the same sub domains, hosts, allowed private records
+// and record options
+func sameSubDomains(a, b *Domain) bool {
+	if a.DomainName != b.DomainName || len(a.SubDomains) != len(b.SubDomains) || len(a.Hosts) != len(b.Hosts) {
+		return false
+	}
+	for i := range a.SubDomains {
+		if a.SubDomains[i] != b.SubDomains[i] {
+			return false
+		}
+	}
+	for i := range a.Hosts {
+		if a.Hosts[i] != b.Hosts[i] {
+			return false
+		}
+	}
+	if len(a.AllowPrivate) != len(b.AllowPrivate) {
+		return false
+	}
+	for i := range a.AllowPrivate {
+		if a.AllowPrivate[i] != b.AllowPrivate[i] {
+			return false
+		}
+	}
+	if !reflect.DeepEqual(a.Records, b.Records) {
+		return false
+	}
+	return a.PrefixInterface == b.PrefixInterface && a.PrefixLength == b.PrefixLength
+}
+
+// syncDomain points all the sub domains of the domain to the current IP, and the hosts to their
+// IPs. Records whose published IP or ID is in the state store are handled without listing the
+// records again.
+func syncDomain(ctx context.Context, provider Provider, configuration *Settings, domain *Domain, currentIP string,
+	hosts map[string]string, store *StateStore) error {
+	recordType := GetRecordType(configuration)
+	lister, canList := provider.(RecordLister)
+
+	// the IP of each sub domain, in order
+	subDomains := append([]string{}, domain.SubDomains...)
+	ips := make(map[string]string, len(subDomains)+len(hosts))
+	for _, subDomain := range domain.SubDomains {
+		ips[subDomain] = currentIP
+	}
+	for i := range domain.Hosts {
+		if ip, ok := hosts[domain.Hosts[i].SubDomain]; ok {
+			subDomains = append(subDomains, domain.Hosts[i].SubDomain)
+			ips[domain.Hosts[i].SubDomain] = ip
+		}
+	}
+	listDomain := *domain
+	listDomain.SubDomains = subDomains
+
+	// records are listed at most once, and only if needed
+	var records map[string]*Record
+	listRecords := func() error {
+		if records != nil {
+			return nil
+		}
+
+		log.Println("Checking IP for domain", domain.DomainName)
+		list, err := lister.GetRecords(ctx, &listDomain, recordType)
+		if err != nil {
+			return err
+		}
+
+		records = make(map[string]*Record, len(list))
+		for _, record := range list {
+			records[record.SubDomain] = record
+		}
+		return nil
+	}
+
+	// the records refused to point to non-public IPs, by IP
+	refused := map[string][]string{}
+	failed := 0
+	for _, subDomain := range subDomains {
+		// don't start new updates once shutting down
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		currentIP := ips[subDomain]
+
+		record := &Record{
+			Domain:    domain.DomainName,
+			SubDomain: subDomain,
+			Type:      recordType,
+			Options:   domain.Options(subDomain),
+		}
+
+		if BogonKind(currentIP) != "" && !allowBogon(domain, subDomain) {
+			log.Printf("%s Refused to update record to %s, it is a %s address\n", record.Name(), currentIP, BogonKind(currentIP))
+			refused[currentIP] = append(refused[currentIP], record.Name())
+			continue
+		}
+
+		// the options are set again when they change, even if the IP doesn't
+		options := optionsKey(record.Options)
+		key := StateKey(configuration, record)
+		state, known := store.Get(key)
+		if known && sameIP(state.IP, currentIP) && state.Options == options {
+			log.Printf("%s Record OK (published): %s\n", record.Name(), state.IP)
+			continue
+		}
+
+		if canList && known && state.RecordID != "" {
+			// update the known record directly
+			record.ID = state.RecordID
+			record.ZoneID = state.ZoneID
+			record.IP = state.IP
+		} else if canList {
+			if err := listRecords(); err != nil {
+				return err
+			}
+
+			found, ok := records[subDomain]
+			if ok {
+				found.Options = record.Options
+				record = found
+			} else if creator, ok := provider.(RecordCreator); ok {
+				log.Printf("%s Record not found, creating it...\n", record.Name())
+				if err := creator.CreateRecord(ctx, record, currentIP); err != nil {
+					log.Printf("%s Failed to create record: %v\n", record.Name(), err)
+					failed++
+					continue
+				}
+				published(store, key, record, currentIP, true)
+				notify(configuration, record.Name(), currentIP)
+				continue
+			} else {
+				log.Printf("%s Record not configured yet, skip it.\n", record.Name())
+				continue
+			}
+		}
+
+		if record.IP != "" && sameIP(record.IP, currentIP) && (options == "" || known && state.Options == options) {
+			log.Printf("%s Record OK: %s\n", record.Name(), record.IP)
+			published(store, key, record, currentIP, false)
+			continue
+		}
+
+		log.Printf("%s Start to update record IP...\n", record.Name())
+		if err := provider.UpdateRecord(ctx, record, currentIP); err != nil {
+			log.Printf("%s Failed to update record: %v\n", record.Name(), err)
+			// the stored record may be gone, list the records next time
+			store.Delete(key)
+			failed++
+			continue
+		}
+		if record.IP != "" && sameIP(record.IP, currentIP) {
+			log.Printf("%s Options updated\n", record.Name())
+			published(store, key, record, currentIP, false)
+			continue
+		}
+		log.Printf("%s IP updated to: %s\n", record.Name(), currentIP)
+		published(store, key, record, currentIP, true)
+		notify(configuration, record.Name(), currentIP)
+	}
+
+	for ip, names := range refused {
+		refuse(configuration, ip, names)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d record(s) failed", failed)
+	}
+	return nil
+}
+
+// published stores the record pointing to the IP in the state store
+func published(store *StateStore, key string, record *Record, ip string, updated bool) {
+	now := time.Now()
+	state, _ := store.Get(key)
+	if updated || !sameIP(state.IP, ip) {
+		state.UpdatedAt = now
+	}
+
+	state.IP = ip
+	state.Options = optionsKey(record.Options)
+	state.RecordID = record.ID
+	state.ZoneID = record.ZoneID
+	state.CheckedAt = now
+	store.Set(key, state)
+}
+
+// optionsKey returns the options of a record as a string to compare them, empty if none
+func optionsKey(options *RecordOptions) string {
+	if options == nil {
+		return ""
+	}
+	key, _ := json.Marshal(options)
+	return string(key)
+}
+
+// notify sends mail notification if notify is enabled
+func notify(configuration *Settings, domain, currentIP string) {
+	if !configuration.Notify.Enabled {
+		return
+	}
+
+	log.Print("Sending notification to:", configuration.Notify.SendTo)
+	if err := SendNotify(configuration, domain, currentIP); err != nil {
+		log.Println("Failed to send notification")
+	}
+}
+
+// sameIP compares two IP addresses, ignoring the different text forms of IPv6
+func sameIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+	return ipA.Equal(ipB)
+}
@@ -0,0 +1,189 @@
+package godns
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeProvider struct {
+	updated map[string]string
+	fail    map[string]bool
+}
+
+func (p *fakeProvider) UpdateRecord(ctx context.Context, record *Record, ip string) error {
+	if p.fail[record.SubDomain] {
+		return errors.New("update failed")
+	}
+	p.updated[record.SubDomain] = ip
+	return nil
+}
+
+type fakeLister struct {
+	fakeProvider
+	records []*Record
+	listed  int
+}
+
+func (p *fakeLister) GetRecords(ctx context.Context, domain *Domain, recordType string) ([]*Record, error) {
+	p.listed++
+	return p.records, nil
+}
+
+func TestSyncDomain(t *testing.T) {
+	conf := &Settings{}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www", "test", "missing"}}
+
+	provider := &fakeLister{
+		fakeProvider: fakeProvider{updated: map[string]string{}},
+		records: []*Record{
+			{ID: "1", Domain: "example.com", SubDomain: "www", Type: "A", IP: "1.1.1.1"},
+			{ID: "2", Domain: "example.com", SubDomain: "test", Type: "A", IP: "2.2.2.2"},
+		},
+	}
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "2.2.2.2", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if provider.updated["www"] != "2.2.2.2" {
+		t.Error("www should be updated to the current IP")
+	}
+	if _, ok := provider.updated["test"]; ok {
+		t.Error("test already points to the current IP, should not be updated")
+	}
+	if _, ok := provider.updated["missing"]; ok {
+		t.Error("missing record should be skipped")
+	}
+}
+
+func TestSyncDomainWithoutLister(t *testing.T) {
+	conf := &Settings{}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www", "test"}}
+	provider := &fakeProvider{updated: map[string]string{}, fail: map[string]bool{"test": true}}
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "2.2.2.2", nil, nil); err == nil {
+		t.Error("failed update should be reported")
+	}
+	if provider.updated["www"] != "2.2.2.2" {
+		t.Error("a failed record should not block the others")
+	}
+}
+
+func TestSyncDomainWithState(t *testing.T) {
+	conf := &Settings{Provider: "Cloudflare"}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www"}}
+	provider := &fakeLister{
+		fakeProvider: fakeProvider{updated: map[string]string{}},
+		records:      []*Record{{ID: "1", ZoneID: "zone", Domain: "example.com", SubDomain: "www", Type: "A", IP: "1.1.1.1"}},
+	}
+	dir, err := ioutil.TempDir("", "godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewStateStore(filepath.Join(dir, "state.json"))
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "2.2.2.2", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := store.Get("Cloudflare/www.example.com/A")
+	if state.IP != "2.2.2.2" || state.RecordID != "1" || state.ZoneID != "zone" {
+		t.Errorf("published record should be stored, got %+v", state)
+	}
+
+	// the published IP is not checked again
+	provider.updated = map[string]string{}
+	if err := syncDomain(context.Background(), provider, conf, domain, "2.2.2.2", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	if provider.listed != 1 || len(provider.updated) != 0 {
+		t.Error("published IP should not be listed or updated again")
+	}
+
+	// a known record is updated without listing
+	if err := syncDomain(context.Background(), provider, conf, domain, "3.3.3.3", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	if provider.listed != 1 || provider.updated["www"] != "3.3.3.3" {
+		t.Error("known record should be updated without listing the records")
+	}
+}
+
+func TestSyncDomainOptions(t *testing.T) {
+	conf := &Settings{Provider: "Cloudflare"}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www"}}
+	provider := &fakeLister{
+		fakeProvider: fakeProvider{updated: map[string]string{}},
+		records:      []*Record{{ID: "1", ZoneID: "zone", Domain: "example.com", SubDomain: "www", Type: "A", IP: "1.1.1.1"}},
+	}
+	dir, err := ioutil.TempDir("", "godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewStateStore(filepath.Join(dir, "state.json"))
+
+	if err := syncDomain(context.Background(), provider, conf, domain, "1.1.1.1", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.updated) != 0 {
+		t.Error("record already points to the IP, should not be updated")
+	}
+
+	// new options are set even if the IP doesn't change
+	proxied := true
+	updated := *domain
+	updated.Records = []RecordOptions{{SubDomain: "www", Proxied: &proxied}}
+	if sameSubDomains(domain, &updated) {
+		t.Error("domains with different record options should not be the same")
+	}
+	if err := syncDomain(context.Background(), provider, conf, &updated, "1.1.1.1", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	if provider.updated["www"] != "1.1.1.1" {
+		t.Error("record should be updated with the new options")
+	}
+
+	provider.updated = map[string]string{}
+	if err := syncDomain(context.Background(), provider, conf, &updated, "1.1.1.1", nil, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.updated) != 0 {
+		t.Error("options are set already, should not be updated again")
+	}
+}
+
+func TestSameIP(t *testing.T) {
+	if !sameIP("2001:db8::1", "2001:0db8:0:0::1") {
+		t.Error("different forms of the same IPv6 address should be equal")
+	}
+	if sameIP("1.1.1.1", "1.1.1.2") {
+		t.Error("different addresses should not be equal")
+	}
+}
+
+func TestDomainWorkerUpdate(t *testing.T) {
+	provider := &fakeProvider{updated: map[string]string{}}
+	conf := &Settings{Interval: 300}
+	domain := &Domain{DomainName: "example.com", SubDomains: []string{"www"}}
+	worker := NewDomainWorker(provider, conf, domain, nil)
+
+	newConf := &Settings{Interval: 60}
+	worker.Update(provider, newConf, &Domain{DomainName: "example.com", SubDomains: []string{"www"}})
+	if _, configuration, _, resync := worker.current(); resync || configuration != newConf {
+		t.Error("new settings should be applied in place, without dropping the cached IP")
+	}
+
+	worker.Update(provider, newConf, &Domain{DomainName: "example.com", SubDomains: []string{"www", "test"}})
+	if _, _, domain, resync := worker.current(); !resync || len(domain.SubDomains) != 2 {
+		t.Error("cached IP should be dropped when the sub domains changed")
+	}
+
+	worker.Update(&fakeProvider{}, newConf, &Domain{DomainName: "example.com", SubDomains: []string{"www", "test"}})
+	if _, _, _, resync := worker.current(); !resync {
+		t.Error("cached IP should be dropped when the provider changed")
+	}
+}
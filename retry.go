@@ -0,0 +1,76 @@
+package godns
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultRetryInitialDelay is the delay before the first retry, in seconds
+	DefaultRetryInitialDelay = 10
+	// DefaultRetryMaxDelay is the max delay between retries, in seconds
+	DefaultRetryMaxDelay = 5 * 60
+	// DefaultRetryMultiplier is how much the delay grows after each retry
+	DefaultRetryMultiplier = 2
+	// DefaultRetryJitter is the random part of the delay, as a fraction of it
+	DefaultRetryJitter = 0.2
+)
+
+// Backoff gives the delays between the retries of a RetryPolicy, growing
+// exponentially with jitter up to the max delay
+type Backoff struct {
+	initial    float64
+	max        float64
+	multiplier float64
+	jitter     float64
+	attempt    int
+}
+
+// NewBackoff creates a backoff for the policy, zero fields are set to the defaults
+func NewBackoff(policy RetryPolicy) *Backoff {
+	b := &Backoff{
+		initial:    float64(policy.InitialDelay),
+		max:        float64(policy.MaxDelay),
+		multiplier: policy.Multiplier,
+		jitter:     policy.Jitter,
+	}
+
+	if b.initial <= 0 {
+		b.initial = DefaultRetryInitialDelay
+	}
+	if b.max <= 0 {
+		b.max = DefaultRetryMaxDelay
+	}
+	if b.max < b.initial {
+		b.max = b.initial
+	}
+	if b.multiplier < 1 {
+		b.multiplier = DefaultRetryMultiplier
+	}
+	if b.jitter <= 0 || b.jitter > 1 {
+		b.jitter = DefaultRetryJitter
+	}
+
+	return b
+}
+
+// Next returns the delay before the next retry
+func (b *Backoff) Next() time.Duration {
+	delay := b.initial
+	for i := 0; i < b.attempt && delay < b.max; i++ {
+		delay *= b.multiplier
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	b.attempt++
+
+	// spread the retries by +/- jitter
+	delay += delay * b.jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay * float64(time.Second))
+}
+
+// Reset starts over from the initial delay, after a success
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
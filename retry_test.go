@@ -0,0 +1,39 @@
+package godns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	backoff := NewBackoff(RetryPolicy{InitialDelay: 10, MaxDelay: 60, Multiplier: 2, Jitter: 0.1})
+
+	expected := []time.Duration{10, 20, 40, 60, 60}
+	for i, base := range expected {
+		delay := backoff.Next()
+		min := time.Duration(float64(base*time.Second) * 0.9)
+		max := time.Duration(float64(base*time.Second) * 1.1)
+		if delay < min || delay > max {
+			t.Errorf("retry %d: delay should be about %ds, got %v", i, base, delay)
+		}
+	}
+
+	backoff.Reset()
+	if delay := backoff.Next(); delay > 11*time.Second {
+		t.Errorf("delay should start over after reset, got %v", delay)
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	backoff := NewBackoff(RetryPolicy{})
+	if delay := backoff.Next(); delay < 8*time.Second || delay > 12*time.Second {
+		t.Errorf("default initial delay should be about %ds, got %v", DefaultRetryInitialDelay, delay)
+	}
+
+	for i := 0; i < 20; i++ {
+		backoff.Next()
+	}
+	if delay := backoff.Next(); delay > time.Duration(DefaultRetryMaxDelay*(1+DefaultRetryJitter))*time.Second {
+		t.Errorf("delay should not exceed the max delay, got %v", delay)
+	}
+}
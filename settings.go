@@ -6,10 +6,77 @@ import (
 	"io/ioutil"
 )
 
+// Host is a LAN host whose IPv6 address is made of the delegated prefix and its interface ID
+type Host struct {
+	SubDomain string `json:"sub_domain"`
+	// InterfaceID is the host part of the address like ::1234
+	InterfaceID string `json:"interface_id,omitempty"`
+	// MAC address to derive an EUI-64 interface ID from, if InterfaceID is not set
+	MAC string `json:"mac,omitempty"`
+}
+
+// TTLAuto is the automatic TTL of Cloudflare, "auto" in the config
+const TTLAuto TTL = 1
+
+// TTL of a record in seconds, or "auto"
+type TTL int
+
+// UnmarshalJSON accepts a number or "auto"
+func (t *TTL) UnmarshalJSON(data []byte) error {
+	if string(data) == `"auto"` {
+		*t = TTLAuto
+		return nil
+	}
+
+	var ttl int
+	if err := json.Unmarshal(data, &ttl); err != nil {
+		return fmt.Errorf("invalid ttl: %s", data)
+	}
+	*t = TTL(ttl)
+	return nil
+}
+
+// RecordOptions are the options of a record, set when it is created or updated and whenever
+// they change. The ones not set are left as they are. Only Cloudflare supports them.
+type RecordOptions struct {
+	SubDomain string   `json:"sub_domain"`
+	Proxied   *bool    `json:"proxied,omitempty"`
+	TTL       TTL      `json:"ttl,omitempty"`
+	Comment   string   `json:"comment,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
 // Domain struct
 type Domain struct {
 	DomainName string   `json:"domain_name"`
 	SubDomains []string `json:"sub_domains"`
+	// Hosts get AAAA records made of the prefix of PrefixInterface and their interface IDs
+	Hosts           []Host `json:"hosts,omitempty"`
+	PrefixInterface string `json:"prefix_interface,omitempty"`
+	PrefixLength    int    `json:"prefix_length,omitempty"`
+	// AllowPrivate are the sub domains and hosts which may point to CGNAT, private and other
+	// addresses not reachable from the internet, the others are not updated to such addresses
+	AllowPrivate []string `json:"allow_private,omitempty"`
+	// Records are the options of the sub domains and hosts
+	Records []RecordOptions `json:"records,omitempty"`
+	// Provider and credentials for this domain, fallback to the global ones if not set
+	Provider   string `json:"provider,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Password   string `json:"password,omitempty"`
+	LoginToken string `json:"login_token,omitempty"`
+	Api        string `json:"api,omitempty"`
+	// ZoneID of the domain at Cloudflare, so that a token scoped to the zone doesn't need to list the zones
+	ZoneID string `json:"zone_id,omitempty"`
+}
+
+// Options returns the options of the sub domain, nil if it has none
+func (domain *Domain) Options(subDomain string) *RecordOptions {
+	for i := range domain.Records {
+		if domain.Records[i].SubDomain == subDomain {
+			return &domain.Records[i]
+		}
+	}
+	return nil
 }
 
 // Notify struct for SMTP notification
@@ -22,23 +89,107 @@ type Notify struct {
 	SendTo       string `json:"send_to"`
 }
 
+// RetryPolicy struct for exponential backoff after failures, zero values are set to the defaults
+type RetryPolicy struct {
+	// InitialDelay is the delay before the first retry, in seconds
+	InitialDelay int `json:"initial_delay"`
+	// MaxDelay is the max delay between retries, in seconds
+	MaxDelay int `json:"max_delay"`
+	// Multiplier is how much the delay grows after each retry
+	Multiplier float64 `json:"multiplier"`
+	// Jitter is the random part of the delay, as a fraction of it (0-1)
+	Jitter float64 `json:"jitter"`
+}
+
+// Retry struct for the retry policies of IP detection and provider API calls
+type Retry struct {
+	Detect   RetryPolicy `json:"detect"`
+	Provider RetryPolicy `json:"provider"`
+}
+
+// RestartPolicy struct for restarting crashed domain loops, zero values are set to the defaults
+type RestartPolicy struct {
+	// MaxRestarts is how many restarts of a domain loop are allowed within the window
+	MaxRestarts int `json:"max_restarts"`
+	// Window is the time window of the restart budget, in seconds
+	Window int `json:"window"`
+	// Backoff is the delay before restarting a crashed domain loop
+	Backoff RetryPolicy `json:"backoff"`
+}
+
+// HoldDown struct for publishing a changed IP only once it is stable, zero values disable each condition
+type HoldDown struct {
+	// Checks is how many consecutive checks must get the new IP
+	Checks int `json:"checks"`
+	// Duration is how long the new IP must be seen, in seconds
+	Duration int `json:"duration"`
+}
+
+// IPSource struct for a way to get the current IP
+type IPSource struct {
+	// Name of the source in the logs, the URL or interface is used if not set
+	Name string `json:"name,omitempty"`
+	// Type of the source: http (default), interface, dns, stun, gateway, command or file
+	Type string `json:"type,omitempty"`
+	// IPType limits the source to IPv4 or IPv6, it is used for both if not set
+	IPType string `json:"ip_type,omitempty"`
+	// Timeout of the source, in seconds
+	Timeout int `json:"timeout,omitempty"`
+	// URL of the IP echo service for http sources, or the device description for gateway sources
+	URL string `json:"url,omitempty"`
+	// Format of the response: text (default), json, trace or regex, for http, command and file sources
+	Format string `json:"format,omitempty"`
+	// Field is the JSON field path for json format, or the key for trace format
+	Field string `json:"field,omitempty"`
+	// Regex matches the IP for regex format, the first group is used if it has one
+	Regex string `json:"regex,omitempty"`
+	// Interface to get the IP from, a name or a glob like ppp*, for interface sources
+	Interface string `json:"interface,omitempty"`
+	// Allow and Deny are CIDR lists the address should be in and not in, for interface sources
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Index of the address to use among the matching ones, the first one by default, for interface sources
+	Index int `json:"index,omitempty"`
+	// Resolver to ask, host or host:port, for dns sources
+	Resolver string `json:"resolver,omitempty"`
+	// Query name and type (A, AAAA or TXT), for dns sources
+	Query     string `json:"query,omitempty"`
+	QueryType string `json:"query_type,omitempty"`
+	// Servers to send the Binding Request to in order, host or host:port, for stun sources
+	Servers []string `json:"servers,omitempty"`
+	// Gateway address and the protocols (upnp, natpmp, pcp) to ask it with in order, for gateway sources
+	Gateway   string   `json:"gateway,omitempty"`
+	Protocols []string `json:"protocols,omitempty"`
+	// Command to run and its arguments, for command sources
+	Command []string `json:"command,omitempty"`
+	// Path of the file to read, for file sources
+	Path string `json:"path,omitempty"`
+}
+
 // Settings struct
 type Settings struct {
-	Provider    string   `json:"provider"`
-	Email       string   `json:"email"`
-	Password    string   `json:"password"`
-	LoginToken  string   `json:"login_token"`
-	Domains     []Domain `json:"domains"`
-	Api         string   `json:"api"`
-	IPUrl       string   `json:"ip_url"`
-	Interval    int      `json:"interval"`
-	UserAgent   string   `json:"user_agent,omitempty"`
-	LogPath     string   `json:"log_path"`
-	Socks5Proxy string   `json:"socks5_proxy"`
-	Notify      Notify   `json:"notify"`
-	IPInterface string   `json:"ip_interface"`
-	// the code is not ready to update AAAA record
-	// IPType      string   `json:"ip_type"`
+	Provider    string        `json:"provider"`
+	Email       string        `json:"email"`
+	Password    string        `json:"password"`
+	LoginToken  string        `json:"login_token"`
+	Domains     []Domain      `json:"domains"`
+	Api         string        `json:"api"`
+	IPUrl       string        `json:"ip_url"`
+	IPV6Url     string        `json:"ipv6_url"`
+	Interval    int           `json:"interval"`
+	UserAgent   string        `json:"user_agent,omitempty"`
+	LogPath     string        `json:"log_path"`
+	Socks5Proxy string        `json:"socks5_proxy"`
+	Notify      Notify        `json:"notify"`
+	IPInterface string        `json:"ip_interface"`
+	IPSources   []IPSource    `json:"ip_sources"`
+	IPStrategy  string        `json:"ip_strategy"`
+	IPQuorum    int           `json:"ip_quorum"`
+	IPType      string        `json:"ip_type"`
+	StatePath   string        `json:"state_path"`
+	Retry       Retry         `json:"retry"`
+	Restart     RestartPolicy `json:"restart"`
+	HoldDown    HoldDown      `json:"hold_down"`
 }
 
 // LoadSettings -- Load settings from config file
@@ -63,3 +214,31 @@ func LoadSettings(configPath string, settings *Settings) error {
 
 	return nil
 }
+
+// GetDomainSettings returns a copy of the settings with the provider and credentials of the domain.
+// Global credentials are only inherited when the domain uses the global provider.
+func GetDomainSettings(settings *Settings, domain *Domain) *Settings {
+	conf := *settings
+	if domain.Provider != "" && domain.Provider != settings.Provider {
+		conf.Provider = domain.Provider
+		conf.Email = ""
+		conf.Password = ""
+		conf.LoginToken = ""
+		conf.Api = ""
+	}
+
+	if domain.Email != "" {
+		conf.Email = domain.Email
+	}
+	if domain.Password != "" {
+		conf.Password = domain.Password
+	}
+	if domain.LoginToken != "" {
+		conf.LoginToken = domain.LoginToken
+	}
+	if domain.Api != "" {
+		conf.Api = domain.Api
+	}
+
+	return &conf
+}
@@ -1,6 +1,7 @@
 package godns
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -21,3 +22,55 @@ func TestLoadSetting(t *testing.T) {
 		t.Error("file doesn't exist, should return error")
 	}
 }
+
+func TestGetDomainSettings(t *testing.T) {
+	settings := &Settings{Provider: "Cloudflare", Email: "user@example.com", Password: "key"}
+
+	conf := GetDomainSettings(settings, &Domain{DomainName: "example.com"})
+	if conf.Provider != "Cloudflare" || conf.Email != "user@example.com" || conf.Password != "key" {
+		t.Error("domain without provider should use the global one")
+	}
+
+	conf = GetDomainSettings(settings, &Domain{DomainName: "example.org", Password: "another_key"})
+	if conf.Email != "user@example.com" || conf.Password != "another_key" {
+		t.Error("domain credentials should override the global ones")
+	}
+
+	conf = GetDomainSettings(settings, &Domain{DomainName: "www.duckdns.org", Provider: "DuckDNS", LoginToken: "token"})
+	if conf.Provider != "DuckDNS" || conf.LoginToken != "token" {
+		t.Error("domain provider should override the global one")
+	}
+	if conf.Email != "" || conf.Password != "" {
+		t.Error("global credentials should not be passed to another provider")
+	}
+
+	if settings.Provider != "Cloudflare" || settings.Password != "key" {
+		t.Error("global settings should not be changed")
+	}
+}
+
+func TestRecordOptions(t *testing.T) {
+	var domain Domain
+	err := json.Unmarshal([]byte(`{"domain_name": "example.com", "sub_domains": ["www", "api", "test"], "records": [
+		{"sub_domain": "www", "proxied": true, "ttl": "auto", "comment": "home", "tags": ["godns"]},
+		{"sub_domain": "api", "ttl": 120}
+	]}`), &domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options := domain.Options("www"); options == nil || options.Proxied == nil || !*options.Proxied ||
+		options.TTL != TTLAuto || options.Comment != "home" || len(options.Tags) != 1 {
+		t.Errorf("www should have its options, got %+v", options)
+	}
+	if options := domain.Options("api"); options == nil || options.Proxied != nil || options.TTL != 120 {
+		t.Errorf("api should have its TTL only, got %+v", options)
+	}
+	if options := domain.Options("test"); options != nil {
+		t.Errorf("test should have no options, got %+v", options)
+	}
+
+	if err := json.Unmarshal([]byte(`{"sub_domain": "www", "ttl": "1h"}`), &RecordOptions{}); err == nil {
+		t.Error("invalid ttl, should be failed")
+	}
+}
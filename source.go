@@ -0,0 +1,295 @@
+package godns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SourceHTTP gets the IP from an IP echo service
+	SourceHTTP = "http"
+	// SourceInterface gets the IP from a network interface
+	SourceInterface = "interface"
+	// StrategyFirst uses the IP of the first source that succeeds, in order
+	StrategyFirst = "first"
+	// StrategyMajority uses the IP that enough of the sources agree on
+	StrategyMajority = "majority"
+	// DefaultSourceTimeout is the timeout of an IP source, in seconds
+	DefaultSourceTimeout = 10
+)
+
+// String returns the name of the source in the logs
+func (s *IPSource) String() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.URL != "":
+		return s.URL
+	case s.Interface != "":
+		return s.Interface
+	case s.Resolver != "" || s.Query != "":
+		return "dns:" + s.Query + "@" + s.Resolver
+	case s.Path != "":
+		return s.Path
+	case len(s.Command) > 0:
+		return strings.Join(s.Command, " ")
+	case s.Gateway != "":
+		return sourceType(s) + ":" + s.Gateway
+	case len(s.Servers) > 0:
+		return sourceType(s) + ":" + strings.Join(s.Servers, ",")
+	}
+	return sourceType(s)
+}
+
+// sourceType returns the type of the source, http if not set
+func sourceType(source *IPSource) string {
+	if source.Type == "" {
+		return SourceHTTP
+	}
+	return strings.ToLower(source.Type)
+}
+
+// GetIPSources returns the IP sources for the configured IP type. If ip_sources is not set,
+// ip_url (or ipv6_url) and ip_interface are used, in this order.
+func GetIPSources(configuration *Settings) []IPSource {
+	var sources []IPSource
+	if len(configuration.IPSources) == 0 {
+		if url := GetIPUrl(configuration); url != "" {
+			sources = append(sources, IPSource{Type: SourceHTTP, URL: url})
+		}
+		if configuration.IPInterface != "" {
+			sources = append(sources, IPSource{Type: SourceInterface, Interface: configuration.IPInterface})
+		}
+		return sources
+	}
+
+	for _, source := range configuration.IPSources {
+		if source.IPType == "" || strings.EqualFold(source.IPType, IPV6) == IsIPv6(configuration) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// GetIPFromSource gets the current IP from the source, within the timeout of the source
+func GetIPFromSource(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	timeout := source.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSourceTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	switch sourceType(source) {
+	case SourceHTTP:
+		return getIPFromHTTP(ctx, configuration, source)
+	case SourceInterface:
+		return GetIPFromInterfaceSource(configuration, source)
+	case SourceDNS:
+		return GetIPFromDNS(ctx, configuration, source)
+	case SourceSTUN:
+		return GetIPFromSTUN(ctx, configuration, source)
+	case SourceGateway:
+		return GetIPFromGateway(ctx, configuration, source)
+	case SourceCommand:
+		return GetIPFromCommand(ctx, configuration, source)
+	case SourceFile:
+		return GetIPFromFile(configuration, source)
+	}
+	return "", fmt.Errorf("unknown IP source type: %s", source.Type)
+}
+
+// GetCurrentIP gets an IP from the IP sources, depending on configuration
+func GetCurrentIP(ctx context.Context, configuration *Settings) (string, error) {
+	sources := GetIPSources(configuration)
+	if len(sources) == 0 {
+		return "", errors.New("no IP source for " + GetIPTypes(configuration)[0])
+	}
+
+	if strings.EqualFold(configuration.IPStrategy, StrategyMajority) {
+		return getIPByMajority(ctx, configuration, sources)
+	}
+
+	var err error
+	for i := range sources {
+		var ip string
+		if ip, err = GetIPFromSource(ctx, configuration, &sources[i]); err == nil {
+			return ip, nil
+		}
+		log.Printf("Get IP from %s failed: %v\n", &sources[i], err)
+	}
+
+	return "", err
+}
+
+// getIPByMajority asks all the sources at once, and returns the IP that at least ip_quorum
+// of them agree on, more than half of them by default. The sources that disagree are logged.
+func getIPByMajority(ctx context.Context, configuration *Settings, sources []IPSource) (string, error) {
+	ips := make([]string, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i := range sources {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ips[i], errs[i] = GetIPFromSource(ctx, configuration, &sources[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// the IP with the most votes wins, ties go to the first source in order
+	votes := map[string]int{}
+	winner := ""
+	for i, ip := range ips {
+		if errs[i] != nil {
+			continue
+		}
+		votes[ip]++
+		if winner == "" || votes[ip] > votes[winner] {
+			winner = ip
+		}
+	}
+
+	var disagreed []string
+	for i, ip := range ips {
+		if errs[i] != nil {
+			disagreed = append(disagreed, fmt.Sprintf("%s failed: %v", &sources[i], errs[i]))
+		} else if ip != winner {
+			disagreed = append(disagreed, fmt.Sprintf("%s got %s", &sources[i], ip))
+		}
+	}
+	if len(disagreed) > 0 {
+		log.Printf("IP sources disagreed, %d of %d got %s: %s\n", votes[winner], len(sources), winner,
+			strings.Join(disagreed, "; "))
+	}
+
+	quorum := configuration.IPQuorum
+	if quorum <= 0 {
+		quorum = len(sources)/2 + 1
+	}
+	if winner == "" || votes[winner] < quorum {
+		return "", fmt.Errorf("no IP is agreed by %d of %d sources", quorum, len(sources))
+	}
+	return winner, nil
+}
+
+// checkIPSources checks the IP sources and the strategy
+func checkIPSources(config *Settings) error {
+	if config.IPStrategy != "" && !strings.EqualFold(config.IPStrategy, StrategyFirst) &&
+		!strings.EqualFold(config.IPStrategy, StrategyMajority) {
+		return errors.New("ip_strategy should be first or majority")
+	}
+
+	for i := range config.IPSources {
+		source := &config.IPSources[i]
+		if source.IPType != "" && !strings.EqualFold(source.IPType, IPV4) && !strings.EqualFold(source.IPType, IPV6) {
+			return fmt.Errorf("ip source %s: ip_type should be IPv4 or IPv6", source)
+		}
+
+		switch sourceType(source) {
+		case SourceHTTP:
+			if source.URL == "" {
+				return fmt.Errorf("ip source %s: url cannot be empty", source)
+			}
+			if err := checkResponseFormat(source); err != nil {
+				return fmt.Errorf("ip source %s: %s", source, err.Error())
+			}
+		case SourceInterface:
+			if source.Interface == "" {
+				return fmt.Errorf("ip source %s: interface cannot be empty", source)
+			}
+			if _, err := path.Match(source.Interface, ""); err != nil {
+				return fmt.Errorf("ip source %s: invalid interface pattern", source)
+			}
+			if _, err := parseCIDRs(append(source.Allow, source.Deny...)); err != nil {
+				return fmt.Errorf("ip source %s: %s", source, err.Error())
+			}
+			if source.Index < 0 {
+				return fmt.Errorf("ip source %s: index cannot be negative", source)
+			}
+		case SourceDNS:
+			if _, err := dnsQueryType(config, source); err != nil {
+				return fmt.Errorf("ip source %s: %s", source, err.Error())
+			}
+		case SourceSTUN:
+		case SourceGateway:
+			for _, protocol := range source.Protocols {
+				if !strings.EqualFold(protocol, ProtocolUPnP) && !strings.EqualFold(protocol, ProtocolNATPMP) &&
+					!strings.EqualFold(protocol, ProtocolPCP) {
+					return fmt.Errorf("ip source %s: unknown protocol %s", source, protocol)
+				}
+			}
+		case SourceCommand:
+			if len(source.Command) == 0 {
+				return fmt.Errorf("ip source %s: command cannot be empty", source)
+			}
+			if err := checkResponseFormat(source); err != nil {
+				return fmt.Errorf("ip source %s: %s", source, err.Error())
+			}
+		case SourceFile:
+			if source.Path == "" {
+				return fmt.Errorf("ip source %s: path cannot be empty", source)
+			}
+			if err := checkResponseFormat(source); err != nil {
+				return fmt.Errorf("ip source %s: %s", source, err.Error())
+			}
+		default:
+			return fmt.Errorf("ip source %s: unknown type %s", source, source.Type)
+		}
+	}
+
+	return nil
+}
+
+// errUnexpectedResponse is returned by the parse function of exchangeUDP to skip a response,
+// e.g. a late response of an earlier request
+var errUnexpectedResponse = errors.New("unexpected response")
+
+// exchangeUDP sends the request until parse accepts a response or the context is done.
+// UDP may lose the request or the response, so it is sent again after the retransmission
+// timeout, which is doubled each time.
+func exchangeUDP(ctx context.Context, conn net.Conn, request []byte, retransmit time.Duration,
+	parse func(response []byte) (net.IP, error)) (net.IP, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultSourceTimeout * time.Second)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		readDeadline := time.Now().Add(retransmit)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		retransmit *= 2
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() && time.Now().Before(deadline) && ctx.Err() == nil {
+					break
+				}
+				return nil, err
+			}
+
+			ip, err := parse(buf[:n])
+			if err == errUnexpectedResponse {
+				continue
+			}
+			return ip, err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newIPServer(ip string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, ip)
+	}))
+}
+
+func TestGetIPSources(t *testing.T) {
+	conf := &Settings{IPUrl: "http://ipv4.example.com", IPV6Url: "http://ipv6.example.com", IPInterface: "eth0"}
+	sources := GetIPSources(conf)
+	if len(sources) != 2 || sources[0].URL != conf.IPUrl || sources[1].Interface != "eth0" {
+		t.Errorf("ip_url and ip_interface should be used as sources, got %v", sources)
+	}
+	if sources := GetIPSources(WithIPType(conf, IPV6)); len(sources) != 2 || sources[0].URL != conf.IPV6Url {
+		t.Errorf("ipv6_url should be used as source for IPv6, got %v", sources)
+	}
+
+	conf.IPSources = []IPSource{
+		{URL: "http://ipv4.example.com", IPType: "ipv4"},
+		{URL: "http://ipv6.example.com", IPType: IPV6},
+		{URL: "http://any.example.com"},
+	}
+	if sources := GetIPSources(conf); len(sources) != 2 || sources[1].URL != "http://any.example.com" {
+		t.Errorf("IPv4 should get the IPv4 and untyped sources, got %v", sources)
+	}
+	if sources := GetIPSources(WithIPType(conf, IPV6)); len(sources) != 2 || sources[0].URL != "http://ipv6.example.com" {
+		t.Errorf("IPv6 should get the IPv6 and untyped sources, got %v", sources)
+	}
+}
+
+func TestGetCurrentIPFirst(t *testing.T) {
+	down := newIPServer("1.1.1.1")
+	down.Close()
+	first := newIPServer("2.2.2.2")
+	defer first.Close()
+	second := newIPServer("3.3.3.3")
+	defer second.Close()
+
+	conf := &Settings{IPSources: []IPSource{{URL: down.URL}, {URL: first.URL}, {URL: second.URL}}}
+	if ip, err := GetCurrentIP(context.Background(), conf); err != nil || ip != "2.2.2.2" {
+		t.Errorf("should get the IP of the first source that succeeds, got %s, %v", ip, err)
+	}
+
+	conf.IPSources = []IPSource{{URL: down.URL}}
+	if ip, err := GetCurrentIP(context.Background(), conf); err == nil {
+		t.Errorf("should fail when all the sources fail, got %s", ip)
+	}
+
+	if ip, err := GetCurrentIP(context.Background(), &Settings{}); err == nil {
+		t.Errorf("should fail without sources, got %s", ip)
+	}
+}
+
+func TestGetCurrentIPMajority(t *testing.T) {
+	good := newIPServer("1.1.1.1")
+	defer good.Close()
+	stale := newIPServer("2.2.2.2")
+	defer stale.Close()
+	down := newIPServer("3.3.3.3")
+	down.Close()
+
+	conf := &Settings{
+		IPStrategy: "Majority",
+		IPSources:  []IPSource{{URL: stale.URL}, {URL: good.URL}, {URL: good.URL}},
+	}
+	if ip, err := GetCurrentIP(context.Background(), conf); err != nil || ip != "1.1.1.1" {
+		t.Errorf("should get the IP most sources agree on, got %s, %v", ip, err)
+	}
+
+	conf.IPSources = []IPSource{{URL: stale.URL}, {URL: good.URL}, {URL: down.URL}}
+	if ip, err := GetCurrentIP(context.Background(), conf); err == nil {
+		t.Errorf("should fail without a majority, got %s", ip)
+	}
+
+	conf.IPQuorum = 1
+	if ip, err := GetCurrentIP(context.Background(), conf); err != nil || ip != "2.2.2.2" {
+		t.Errorf("ties should go to the first source, got %s, %v", ip, err)
+	}
+}
+
+func TestGetIPFromSourceTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		fmt.Fprintln(w, "1.1.1.1")
+	}))
+	defer slow.Close()
+
+	start := time.Now()
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, &IPSource{URL: slow.URL, Timeout: 1}); err == nil {
+		t.Errorf("slow source should time out, got %s", ip)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("source should time out after 1 second, took %v", elapsed)
+	}
+}
+
+func TestCheckIPSources(t *testing.T) {
+	conf := &Settings{Provider: "DNSPod", LoginToken: "aaa", IPStrategy: "random"}
+	if err := CheckSettings(conf); err == nil {
+		t.Error("setting with invalid ip_strategy, should be failed")
+	}
+
+	conf = &Settings{Provider: "DNSPod", LoginToken: "aaa", IPSources: []IPSource{{Type: "interface"}}}
+	if err := CheckSettings(conf); err == nil {
+		t.Error("interface source without interface, should be failed")
+	}
+
+	conf = &Settings{Provider: "DNSPod", LoginToken: "aaa", IPType: IPV6,
+		IPSources: []IPSource{{URL: "http://ipv4.example.com", IPType: IPV4}}}
+	if err := CheckSettings(conf); err == nil {
+		t.Error("IPv6 setting without IPv6 source, should be failed")
+	}
+
+	conf.IPSources = append(conf.IPSources, IPSource{URL: "http://ipv6.example.com", IPType: IPV6})
+	if err := CheckSettings(conf); err != nil {
+		t.Error("IPv6 setting with IPv6 source should be passed:", err)
+	}
+}
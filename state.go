@@ -0,0 +1,133 @@
+package godns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RecordState is the last published state of a record
+type RecordState struct {
+	IP       string `json:"ip"`
+	RecordID string `json:"record_id,omitempty"`
+	ZoneID   string `json:"zone_id,omitempty"`
+	// Options are the record options set with the IP, to set them again when they change
+	Options string `json:"options,omitempty"`
+	// UpdatedAt is when the IP was published to the provider
+	UpdatedAt time.Time `json:"updated_at"`
+	// CheckedAt is when the record was last confirmed to point to the IP
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// StateStore keeps the state of the records in a file, so that it survives restarts.
+// A nil StateStore keeps nothing.
+type StateStore struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]RecordState
+}
+
+// NewStateStore creates an empty state store saved to the file
+func NewStateStore(path string) *StateStore {
+	return &StateStore{
+		path:    path,
+		records: map[string]RecordState{},
+	}
+}
+
+// LoadState loads the state file, a missing file gives an empty state
+func LoadState(path string) (*StateStore, error) {
+	store := NewStateStore(path)
+
+	file, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(file, &store.records); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// StateKey returns the key of a record in the state store
+func StateKey(configuration *Settings, record *Record) string {
+	return configuration.Provider + "/" + record.Name() + "/" + record.Type
+}
+
+// Get returns the state of the record
+func (s *StateStore) Get(key string) (RecordState, bool) {
+	if s == nil {
+		return RecordState{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.records[key]
+	return state, ok
+}
+
+// Set stores the state of the record and saves the state file
+func (s *StateStore) Set(key string, state RecordState) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = state
+	s.save()
+}
+
+// Delete drops the state of the record and saves the state file
+func (s *StateStore) Delete(key string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[key]; !ok {
+		return
+	}
+	delete(s.records, key)
+	s.save()
+}
+
+// save writes the state to a temp file and renames it, so a crash never leaves a broken
+// state file behind. The caller must hold the lock.
+func (s *StateStore) save() {
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		log.Println("Failed to encode state:", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		log.Println("Failed to save state:", err)
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Println("Failed to save state:", err)
+	}
+}
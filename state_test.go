@@ -0,0 +1,51 @@
+package godns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	store, err := LoadState(path)
+	if err != nil {
+		t.Fatal("missing state file should give an empty state:", err)
+	}
+
+	store.Set("Cloudflare/www.example.com/A", RecordState{IP: "1.1.1.1", RecordID: "abc", ZoneID: "zone"})
+	store.Set("Cloudflare/test.example.com/A", RecordState{IP: "1.1.1.1"})
+	store.Delete("Cloudflare/test.example.com/A")
+
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, ok := loaded.Get("Cloudflare/www.example.com/A")
+	if !ok || state.IP != "1.1.1.1" || state.RecordID != "abc" || state.ZoneID != "zone" {
+		t.Errorf("state should survive a restart, got %+v", state)
+	}
+	if _, ok := loaded.Get("Cloudflare/test.example.com/A"); ok {
+		t.Error("deleted state should not be loaded")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(path); err == nil {
+		t.Error("broken state file should return error")
+	}
+
+	var disabled *StateStore
+	disabled.Set("key", RecordState{IP: "1.1.1.1"})
+	if _, ok := disabled.Get("key"); ok {
+		t.Error("nil state store should keep nothing")
+	}
+}
@@ -0,0 +1,157 @@
+package godns
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// SourceSTUN gets the IP from the mapped address of a STUN Binding Request
+	SourceSTUN = "stun"
+	// DefaultSTUNServer is the server of the stun source
+	DefaultSTUNServer = "stun.l.google.com:19302"
+
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112a442
+	stunHeaderSize       = 20
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunFamilyIPv4       = 0x01
+	stunFamilyIPv6       = 0x02
+	// stunRetransmit is the initial retransmission timeout
+	stunRetransmit = 500 * time.Millisecond
+)
+
+// GetIPFromSTUN sends a STUN (RFC 5389) Binding Request to the servers of the source in order,
+// and returns the mapped address of the first one that answers. The request is sent over IPv4
+// or IPv6 as configured.
+func GetIPFromSTUN(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	servers := source.Servers
+	if len(servers) == 0 {
+		servers = []string{DefaultSTUNServer}
+	}
+
+	var err error
+	for _, server := range servers {
+		if _, _, e := net.SplitHostPort(server); e != nil {
+			server = net.JoinHostPort(server, "3478")
+		}
+
+		var ip net.IP
+		if ip, err = stunBinding(ctx, configuration, server); err == nil {
+			return ValidateIP(configuration, ip.String())
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", err
+}
+
+// stunBinding sends the Binding Request to the server until it answers or the context is done
+func stunBinding(ctx context.Context, configuration *Settings, server string) (net.IP, error) {
+	network := "udp4"
+	if IsIPv6(configuration) {
+		network = "udp6"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:stunHeaderSize]); err != nil {
+		return nil, err
+	}
+	transactionID := request[8:stunHeaderSize]
+
+	return exchangeUDP(ctx, conn, request, stunRetransmit, func(response []byte) (net.IP, error) {
+		return parseSTUNResponse(response, transactionID)
+	})
+}
+
+// parseSTUNResponse gets the mapped address from a Binding Success Response, XOR-MAPPED-ADDRESS
+// is preferred over MAPPED-ADDRESS
+func parseSTUNResponse(msg []byte, transactionID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderSize || binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie ||
+		!bytes.Equal(msg[8:stunHeaderSize], transactionID) {
+		return nil, errUnexpectedResponse
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != stunBindingSuccess {
+		return nil, errors.New("STUN Binding Request failed")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderSize+length > len(msg) {
+		return nil, errors.New("invalid STUN response")
+	}
+
+	var mapped net.IP
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLength > len(attrs) {
+			return nil, errors.New("invalid STUN attribute")
+		}
+		value := attrs[4 : 4+attrLength]
+
+		switch attrType {
+		case stunXORMappedAddress:
+			return parseSTUNAddress(value, msg[4:stunHeaderSize])
+		case stunMappedAddress:
+			mapped, _ = parseSTUNAddress(value, nil)
+		}
+
+		// attributes are padded to 4 bytes
+		next := 4 + (attrLength+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped == nil {
+		return nil, errors.New("no mapped address in STUN response")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value, key is the magic cookie and
+// the transaction ID to XOR the address with, nil if it is not XORed
+func parseSTUNAddress(value []byte, key []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("invalid STUN address")
+	}
+
+	var ip net.IP
+	switch value[1] {
+	case stunFamilyIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case stunFamilyIPv6:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, errors.New("unknown STUN address family")
+	}
+	if len(value) < 4+len(ip) {
+		return nil, errors.New("invalid STUN address")
+	}
+
+	copy(ip, value[4:])
+	if key != nil {
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip, nil
+}
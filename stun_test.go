@@ -0,0 +1,113 @@
+package godns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// newSTUNServer answers the Binding Requests with the address of the client, in
+// XOR-MAPPED-ADDRESS or MAPPED-ADDRESS. The first request is dropped to test retransmission.
+func newSTUNServer(t *testing.T, network, address string, xor bool) net.PacketConn {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skip("can't listen on", address, err)
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		requests := 0
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderSize || binary.BigEndian.Uint16(buf) != stunBindingRequest {
+				continue
+			}
+			if requests++; requests == 1 {
+				continue
+			}
+
+			udpAddr := addr.(*net.UDPAddr)
+			ip, family := udpAddr.IP.To4(), byte(stunFamilyIPv4)
+			if ip == nil {
+				ip, family = udpAddr.IP.To16(), stunFamilyIPv6
+			}
+
+			value := make([]byte, 4+len(ip))
+			value[1] = family
+			binary.BigEndian.PutUint16(value[2:], uint16(udpAddr.Port))
+			copy(value[4:], ip)
+			attrType := uint16(stunMappedAddress)
+			if xor {
+				attrType = stunXORMappedAddress
+				key := buf[4:stunHeaderSize]
+				for i := range ip {
+					value[4+i] ^= key[i]
+				}
+				binary.BigEndian.PutUint16(value[2:], uint16(udpAddr.Port)^uint16(stunMagicCookie>>16))
+			}
+
+			response := make([]byte, stunHeaderSize, stunHeaderSize+8+len(value))
+			copy(response, buf[:stunHeaderSize])
+			binary.BigEndian.PutUint16(response, stunBindingSuccess)
+
+			// an unknown attribute with padding, before the address
+			response = append(response, 0x80, 0x22, 0, 3, 'g', 'o', 0, 0)
+			attr := make([]byte, 4)
+			binary.BigEndian.PutUint16(attr, attrType)
+			binary.BigEndian.PutUint16(attr[2:], uint16(len(value)))
+			response = append(append(response, attr...), value...)
+			binary.BigEndian.PutUint16(response[2:], uint16(len(response)-stunHeaderSize))
+
+			// a response of another transaction first
+			stale := append([]byte{}, response...)
+			stale[stunHeaderSize-1]++
+			conn.WriteTo(stale, addr)
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestGetIPFromSTUN(t *testing.T) {
+	for _, xor := range []bool{true, false} {
+		server := newSTUNServer(t, "udp4", "127.0.0.1:0", xor)
+		source := &IPSource{Type: SourceSTUN, Servers: []string{server.LocalAddr().String()}, Timeout: 3}
+		if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "127.0.0.1" {
+			t.Errorf("should get the mapped address (xor %v), got %s, %v", xor, ip, err)
+		}
+		server.Close()
+	}
+}
+
+func TestGetIPFromSTUNIPv6(t *testing.T) {
+	server := newSTUNServer(t, "udp6", "[::1]:0", true)
+	defer server.Close()
+
+	source := &IPSource{Type: SourceSTUN, Servers: []string{server.LocalAddr().String()}, Timeout: 3}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{IPType: IPV6}, source); err != nil || ip != "::1" {
+		t.Errorf("should get the IPv6 mapped address, got %s, %v", ip, err)
+	}
+}
+
+func TestGetIPFromSTUNFallback(t *testing.T) {
+	down, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.LocalAddr().String()
+	down.Close()
+
+	server := newSTUNServer(t, "udp4", "127.0.0.1:0", true)
+	defer server.Close()
+
+	// the unreachable server fails right away with connection refused
+	source := &IPSource{Type: SourceSTUN, Servers: []string{downAddr, server.LocalAddr().String()}, Timeout: 3}
+	if ip, err := GetIPFromSource(context.Background(), &Settings{}, source); err != nil || ip != "127.0.0.1" {
+		t.Errorf("should fallback to the next server, got %s, %v", ip, err)
+	}
+}
@@ -0,0 +1,165 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxRestarts is how many restarts of a crashed loop are allowed within the window
+	DefaultMaxRestarts = 5
+	// DefaultRestartWindow is the time window of the restart budget, in seconds
+	DefaultRestartWindow = 60 * 60
+)
+
+// Supervisor runs the loops in goroutines and restarts them with backoff when they panic.
+// Each loop has its own restart budget, a loop running out of it is marked as failed
+// and not restarted any more, while the other ones keep running.
+type Supervisor struct {
+	wg sync.WaitGroup
+
+	mu            sync.Mutex
+	configuration *Settings
+	restarts      map[string][]time.Time
+	failed        map[string]bool
+}
+
+// NewSupervisor creates a supervisor using the restart policy and notify settings of the configuration
+func NewSupervisor(configuration *Settings) *Supervisor {
+	return &Supervisor{
+		configuration: configuration,
+		restarts:      map[string][]time.Time{},
+		failed:        map[string]bool{},
+	}
+}
+
+// SetConfiguration replaces the settings, e.g. on config reload
+func (s *Supervisor) SetConfiguration(configuration *Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configuration = configuration
+}
+
+// Go runs the loop in a new goroutine until it returns or the context is done,
+// a panic restarts it within the restart budget of the name
+func (s *Supervisor) Go(ctx context.Context, name string, loop func(ctx context.Context)) {
+	s.mu.Lock()
+	delete(s.failed, name)
+	delete(s.restarts, name)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		backoff := NewBackoff(s.getConfiguration().Restart.Backoff)
+		for {
+			crash, stack := runLoop(ctx, loop)
+			if crash == nil || ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("Recovered in %s: %v\n%s\n", name, crash, stack)
+			restarts, ok := s.restart(name)
+			if !ok {
+				log.Printf("%s crashed %d times, marked as failed and will not be restarted\n", name, restarts)
+				s.alert(fmt.Sprintf("GoDNS: %s failed", name),
+					fmt.Sprintf("%s crashed %d times and will not be restarted.\n\n%v\n\n%s", name, restarts, crash, stack))
+				return
+			}
+
+			if restarts == 1 {
+				backoff.Reset()
+			}
+			delay := backoff.Next()
+			log.Printf("%s crashed, will restart it in %.0f seconds... (%d)\n", name, delay.Seconds(), restarts)
+			s.alert(fmt.Sprintf("GoDNS: %s crashed", name),
+				fmt.Sprintf("%s crashed and will be restarted in %.0f seconds.\n\n%v\n\n%s", name, delay.Seconds(), crash, stack))
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Wait waits for all the loops to return
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Failed reports whether the loop ran out of its restart budget
+func (s *Supervisor) Failed(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed[name]
+}
+
+// runLoop runs the loop, and returns the recovered panic with its stack if it panics
+func runLoop(ctx context.Context, loop func(ctx context.Context)) (crash interface{}, stack []byte) {
+	defer func() {
+		if err := recover(); err != nil {
+			crash, stack = err, debug.Stack()
+		}
+	}()
+
+	loop(ctx)
+	return nil, nil
+}
+
+// restart records a restart of the loop, and reports whether it is still within the budget
+func (s *Supervisor) restart(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maxRestarts := s.configuration.Restart.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = DefaultMaxRestarts
+	}
+	window := s.configuration.Restart.Window
+	if window <= 0 {
+		window = DefaultRestartWindow
+	}
+
+	// only the restarts within the window count
+	now := time.Now()
+	var restarts []time.Time
+	for _, t := range s.restarts[name] {
+		if now.Sub(t) < time.Duration(window)*time.Second {
+			restarts = append(restarts, t)
+		}
+	}
+	restarts = append(restarts, now)
+	s.restarts[name] = restarts
+
+	if len(restarts) > maxRestarts {
+		s.failed[name] = true
+		return len(restarts), false
+	}
+	return len(restarts), true
+}
+
+// getConfiguration returns the settings in use
+func (s *Supervisor) getConfiguration() *Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.configuration
+}
+
+// alert sends a mail notification about the crash if notify is enabled
+func (s *Supervisor) alert(subject, message string) {
+	configuration := s.getConfiguration()
+	if !configuration.Notify.Enabled {
+		return
+	}
+
+	if err := SendAlert(configuration, subject, message); err != nil {
+		log.Println("Failed to send notification")
+	}
+}
@@ -0,0 +1,53 @@
+package godns
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSupervisor(t *testing.T) {
+	conf := &Settings{Restart: RestartPolicy{MaxRestarts: 1, Backoff: RetryPolicy{InitialDelay: 1, Jitter: 0.01}}}
+	s := NewSupervisor(conf)
+	ctx := context.Background()
+
+	// a loop returning normally is not restarted
+	var done int32
+	s.Go(ctx, "done", func(ctx context.Context) {
+		atomic.AddInt32(&done, 1)
+	})
+
+	// a loop panicking once is restarted
+	var once int32
+	s.Go(ctx, "once", func(ctx context.Context) {
+		if atomic.AddInt32(&once, 1) == 1 {
+			panic("crash")
+		}
+	})
+
+	// a loop panicking all the time runs out of its budget
+	var always int32
+	s.Go(ctx, "always", func(ctx context.Context) {
+		atomic.AddInt32(&always, 1)
+		panic("crash")
+	})
+
+	s.Wait()
+
+	if done != 1 {
+		t.Errorf("loop returning normally should run once, got %d", done)
+	}
+	if once != 2 || s.Failed("once") {
+		t.Errorf("loop panicking once should be restarted once, got %d runs, failed %v", once, s.Failed("once"))
+	}
+	if always != 2 || !s.Failed("always") {
+		t.Errorf("loop panicking all the time should be failed after the budget, got %d runs, failed %v", always, s.Failed("always"))
+	}
+
+	// starting it again gives a new budget
+	s.Go(ctx, "always", func(ctx context.Context) {})
+	s.Wait()
+	if s.Failed("always") {
+		t.Error("restarted loop should not be failed")
+	}
+}
@@ -2,13 +2,17 @@ package godns
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"golang.org/x/net/proxy"
 	gomail "gopkg.in/gomail.v2"
@@ -32,8 +36,6 @@ https://github.com/TimothyYe/godns
 )
 
 const (
-	// PanicMax is the max allowed panic times
-	PanicMax = 5
 	// DNSPOD for dnspod.cn
 	DNSPOD = "DNSPod"
 	// HE for he.net
@@ -46,67 +48,65 @@ const (
 	GOOGLE = "Google"
 	// DUCK for Duck DNS
 	DUCK = "DuckDNS"
+	// IPV4 for IPv4 mode, updates A records
+	IPV4 = "IPv4"
+	// IPV6 for IPv6 mode, updates AAAA records
+	IPV6 = "IPv6"
+	// DUALSTACK for dual-stack mode, updates both A and AAAA records
+	DUALSTACK = "DualStack"
+
+	// maxResponseSize is the max size of a response read from an IP echo service
+	maxResponseSize = 64 * 1024
 )
 
 //GetIPFromInterface gets IP address from the specific interface
 func GetIPFromInterface(configuration *Settings) (string, error) {
-	ifaces, err := net.InterfaceByName(configuration.IPInterface)
-	if err != nil {
-		log.Println("can't get network device "+configuration.IPInterface+":", err)
-		return "", err
-	}
-
-	addrs, err := ifaces.Addrs()
-	if err != nil {
-		log.Println("can't get address from "+configuration.IPInterface+":", err)
-		return "", err
-	}
+	return GetIPFromInterfaceSource(configuration, &IPSource{Type: SourceInterface, Interface: configuration.IPInterface})
+}
 
-	for _, addr := range addrs {
-		var ip net.IP
-		switch v := addr.(type) {
-		case *net.IPNet:
-			ip = v.IP
-		case *net.IPAddr:
-			ip = v.IP
-		}
-		if ip == nil {
-			continue
-		}
+// IsIPv6 reports whether the configuration asks for IPv6 (AAAA) records
+func IsIPv6(configuration *Settings) bool {
+	return strings.EqualFold(configuration.IPType, IPV6)
+}
 
-		if !(ip.IsGlobalUnicast() &&
-			!(ip.IsUnspecified() ||
-				ip.IsMulticast() ||
-				ip.IsLoopback() ||
-				ip.IsLinkLocalUnicast() ||
-				ip.IsLinkLocalMulticast() ||
-				ip.IsInterfaceLocalMulticast())) {
-			continue
-		}
+// IsDualStack reports whether the configuration asks for both A and AAAA records
+func IsDualStack(configuration *Settings) bool {
+	return strings.EqualFold(configuration.IPType, DUALSTACK)
+}
 
-		//the code is not ready for updating an AAAA record
-		/*
-			if (isIPv4(ip.String())){
-				if (configuration.IPType=="IPv6"){
-					continue;
-				}
-			}else{
-				if (configuration.IPType!="IPv6"){
-					continue;
-				}
-			} */
-		if !isIPv4(ip.String()) {
-			continue
-		}
+// GetIPTypes returns the IP types to keep in sync, both of them in dual-stack mode
+func GetIPTypes(configuration *Settings) []string {
+	if IsDualStack(configuration) {
+		return []string{IPV4, IPV6}
+	}
+	if IsIPv6(configuration) {
+		return []string{IPV6}
+	}
+	return []string{IPV4}
+}
 
-		return ip.String(), nil
+// WithIPType returns a copy of the configuration pinned to a single IP type,
+// so that each IP type can be detected and updated on its own
+func WithIPType(configuration *Settings, ipType string) *Settings {
+	conf := *configuration
+	conf.IPType = ipType
+	return &conf
+}
 
+// GetRecordType returns the DNS record type matching the configured IP type
+func GetRecordType(configuration *Settings) string {
+	if IsIPv6(configuration) {
+		return "AAAA"
 	}
-	return "", errors.New("can't get a vaild address from " + configuration.IPInterface)
+	return "A"
 }
 
-func isIPv4(ip string) bool {
-	return strings.Count(ip, ":") < 2
+// GetIPUrl returns the IP echo service for the configured IP type
+func GetIPUrl(configuration *Settings) string {
+	if IsIPv6(configuration) {
+		return configuration.IPV6Url
+	}
+	return configuration.IPUrl
 }
 
 // GetHttpClient creates the HTTP client and return it
@@ -129,50 +129,74 @@ func GetHttpClient(configuration *Settings) *http.Client {
 	return client
 }
 
-//GetCurrentIP gets an IP from either internet or specific interface, depending on configuration
-func GetCurrentIP(configuration *Settings) (string, error) {
-	var err error
+// familyDialer returns a dial function pinned to tcp4 or tcp6 as configured, so that the IP echo
+// service sees the address of the configured IP type, even if its host has both of them. Through
+// the SOCKS5 proxy, the host is resolved locally and the IP of the IP type is dialed via the proxy,
+// since the proxy would pick any of them otherwise.
+func familyDialer(configuration *Settings) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	network := "tcp4"
+	if IsIPv6(configuration) {
+		network = "tcp6"
+	}
 
-	if configuration.IPUrl != "" {
-		ip, err := GetIPOnline(configuration)
-		if err != nil {
-			log.Println("get ip online failed. Fallback to get ip from interface if possible.")
-		} else {
-			return ip, nil
-		}
+	if configuration.Socks5Proxy == "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		return func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}, nil
 	}
 
-	if configuration.IPInterface != "" {
-		ip, err := GetIPFromInterface(configuration)
+	log.Println("use socks5 proxy:" + configuration.Socks5Proxy)
+	dialer, err := proxy.SOCKS5("tcp", configuration.Socks5Proxy, nil, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
 		if err != nil {
-			log.Println("get ip from interface failed. There is no more ways to try.")
-		} else {
-			return ip, nil
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	return "", err
+		for _, ip := range addrs {
+			if (ip.IP.To4() == nil) == IsIPv6(configuration) {
+				addr = net.JoinHostPort(ip.IP.String(), port)
+				if d, ok := dialer.(interface {
+					DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+				}); ok {
+					return d.DialContext(ctx, network, addr)
+				}
+				return dialer.Dial(network, addr)
+			}
+		}
+		return nil, fmt.Errorf("%s has no %s address", host, GetIPTypes(configuration)[0])
+	}, nil
 }
 
 // GetIPOnline gets public IP from internet
-func GetIPOnline(configuration *Settings) (string, error) {
-	client := &http.Client{}
-
-	if configuration.Socks5Proxy != "" {
+func GetIPOnline(ctx context.Context, configuration *Settings) (string, error) {
+	return getIPFromHTTP(ctx, configuration, &IPSource{URL: GetIPUrl(configuration)})
+}
 
-		log.Println("use socks5 proxy:" + configuration.Socks5Proxy)
-		dialer, err := proxy.SOCKS5("tcp", configuration.Socks5Proxy, nil, proxy.Direct)
-		if err != nil {
-			log.Println("can't connect to the proxy:", err)
-			return "", err
-		}
+// getIPFromHTTP gets public IP from the IP echo service of the source
+func getIPFromHTTP(ctx context.Context, configuration *Settings, source *IPSource) (string, error) {
+	dial, err := familyDialer(configuration)
+	if err != nil {
+		log.Println("can't connect to the proxy:", err)
+		return "", err
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: dial, DisableKeepAlives: true}}
 
-		httpTransport := &http.Transport{}
-		client.Transport = httpTransport
-		httpTransport.Dial = dialer.Dial
+	req, err := http.NewRequest("GET", source.URL, nil)
+	if err != nil {
+		return "", err
 	}
 
-	response, err := client.Get(configuration.IPUrl)
+	response, err := client.Do(req.WithContext(ctx))
 
 	if err != nil {
 		log.Println("Cannot get IP...")
@@ -181,12 +205,56 @@ func GetIPOnline(configuration *Settings) (string, error) {
 
 	defer response.Body.Close()
 
-	body, _ := ioutil.ReadAll(response.Body)
-	return strings.Trim(string(body), "\n"), nil
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("%s returned status %s", source.URL, response.Status)
+	}
+
+	// an IP echo service never sends a big response, it's an error page if it does
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := ParseIPResponse(source, body)
+	if err != nil {
+		return "", err
+	}
+	return ValidateIP(configuration, ip)
 }
 
 // CheckSettings check the format of settings
 func CheckSettings(config *Settings) error {
+	if config.IPType != "" && !strings.EqualFold(config.IPType, IPV4) &&
+		!strings.EqualFold(config.IPType, IPV6) && !IsDualStack(config) {
+		return errors.New("ip_type should be IPv4, IPv6 or DualStack")
+	}
+
+	if err := checkIPSources(config); err != nil {
+		return err
+	}
+
+	if (IsIPv6(config) || IsDualStack(config)) && len(GetIPSources(WithIPType(config, IPV6))) == 0 {
+		return errors.New("ipv6_url, ip_interface or an IPv6 ip source is required for IPv6")
+	}
+
+	if len(config.Domains) == 0 {
+		return checkProvider(config)
+	}
+
+	for i := range config.Domains {
+		if err := checkProvider(GetDomainSettings(config, &config.Domains[i])); err != nil {
+			return fmt.Errorf("%s: %s", config.Domains[i].DomainName, err.Error())
+		}
+		if err := checkHosts(config, &config.Domains[i]); err != nil {
+			return fmt.Errorf("%s: %s", config.Domains[i].DomainName, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// checkProvider checks the provider and its credentials
+func checkProvider(config *Settings) error {
 	if config.Provider == DNSPOD {
 		if config.Password == "" && config.LoginToken == "" {
 			return errors.New("password or login token cannot be empty")
@@ -196,11 +264,14 @@ func CheckSettings(config *Settings) error {
 			return errors.New("password cannot be empty")
 		}
 	} else if config.Provider == CLOUDFLARE {
-		if config.Email == "" {
-			return errors.New("email cannot be empty")
-		}
-		if config.Password == "" {
-			return errors.New("password cannot be empty")
+		// an API token, or the email and Global API Key
+		if config.LoginToken == "" {
+			if config.Email == "" {
+				return errors.New("email or login token cannot be empty")
+			}
+			if config.Password == "" {
+				return errors.New("password or login token cannot be empty")
+			}
 		}
 	} else if config.Provider == ALIDNS {
 		if config.Email == "" {
@@ -248,6 +319,24 @@ func SendNotify(configuration *Settings, domain, currentIP string) error {
 	return nil
 }
 
+// SendAlert sends a plain text mail notify, e.g. when a domain loop crashed
+func SendAlert(configuration *Settings, subject, message string) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", configuration.Notify.SMTPUsername)
+	m.SetHeader("To", configuration.Notify.SendTo)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", message)
+
+	d := gomail.NewPlainDialer(configuration.Notify.SMTPServer, configuration.Notify.SMTPPort, configuration.Notify.SMTPUsername, configuration.Notify.SMTPPassword)
+
+	if err := d.DialAndSend(m); err != nil {
+		log.Println("Send email notification with error:", err.Error())
+		return err
+	}
+	return nil
+}
+
 func buildTemplate(currentIP, domain string) string {
 	t := template.New("notification template")
 	if _, err := t.Parse(mailTemplate); err != nil {
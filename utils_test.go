@@ -1,12 +1,13 @@
 package godns
 
 import (
+	"context"
 	"testing"
 )
 
 func TestGetCurrentIP(t *testing.T) {
 	conf := &Settings{IPUrl: "http://members.3322.org/dyndns/getip"}
-	ip, _ := GetCurrentIP(conf)
+	ip, _ := GetCurrentIP(context.Background(), conf)
 
 	if ip == "" {
 		t.Log("IP is empty...")
@@ -15,7 +16,7 @@ func TestGetCurrentIP(t *testing.T) {
 	}
 
 	conf = &Settings{Socks5Proxy: "localhost:8899", IPUrl: "http://members.3322.org/dyndns/getip"}
-	ip, err := GetCurrentIP(conf)
+	ip, err := GetCurrentIP(context.Background(), conf)
 
 	if ip != "" && err == nil {
 		t.Error("should return error")
@@ -46,4 +47,86 @@ func TestCheckSettings(t *testing.T) {
 	} else {
 		t.Error("HE setting without password, should be faild")
 	}
+
+	settingCloudflare := &Settings{Provider: "Cloudflare", LoginToken: "token"}
+	if err := CheckSettings(settingCloudflare); err != nil {
+		t.Error("Cloudflare setting with API token, should be passed:", err)
+	}
+
+	settingCloudflare = &Settings{Provider: "Cloudflare", Email: "user@example.com"}
+	if err := CheckSettings(settingCloudflare); err == nil {
+		t.Error("Cloudflare setting without API key or token, should be failed")
+	}
+}
+
+func TestCheckSettingsIPType(t *testing.T) {
+	settingInvalid := &Settings{Provider: "DNSPod", LoginToken: "aaa", IPType: "IPv5"}
+	if err := CheckSettings(settingInvalid); err == nil {
+		t.Error("setting with invalid ip_type, should be failed")
+	}
+
+	settingNoSource := &Settings{Provider: "DNSPod", LoginToken: "aaa", IPType: "IPv6"}
+	if err := CheckSettings(settingNoSource); err == nil {
+		t.Error("IPv6 setting without ipv6_url or ip_interface, should be failed")
+	}
+
+	settingIPv6 := &Settings{Provider: "DNSPod", LoginToken: "aaa", IPType: "ipv6", IPV6Url: "https://api-ipv6.ip.sb/ip"}
+	if err := CheckSettings(settingIPv6); err != nil {
+		t.Error("IPv6 setting should be passed:", err)
+	}
+}
+
+func TestGetRecordType(t *testing.T) {
+	if recordType := GetRecordType(&Settings{}); recordType != "A" {
+		t.Errorf("default record type should be A, got %s", recordType)
+	}
+
+	conf := &Settings{IPType: IPV6, IPUrl: "http://ipv4.example.com", IPV6Url: "http://ipv6.example.com"}
+	if recordType := GetRecordType(conf); recordType != "AAAA" {
+		t.Errorf("record type should be AAAA for IPv6, got %s", recordType)
+	}
+
+	if url := GetIPUrl(conf); url != conf.IPV6Url {
+		t.Errorf("should use ipv6_url for IPv6, got %s", url)
+	}
+}
+
+func TestGetIPTypes(t *testing.T) {
+	conf := &Settings{IPType: DUALSTACK, IPUrl: "http://ipv4.example.com", IPV6Url: "http://ipv6.example.com"}
+	ipTypes := GetIPTypes(conf)
+	if len(ipTypes) != 2 || ipTypes[0] != IPV4 || ipTypes[1] != IPV6 {
+		t.Fatalf("dual-stack should update both IPv4 and IPv6, got %v", ipTypes)
+	}
+
+	for _, ipType := range ipTypes {
+		pinned := WithIPType(conf, ipType)
+		if pinned.IPType != ipType {
+			t.Errorf("pinned configuration should be %s, got %s", ipType, pinned.IPType)
+		}
+	}
+
+	if conf.IPType != DUALSTACK {
+		t.Error("pinning an IP type should not change the original configuration")
+	}
+
+	if GetRecordType(WithIPType(conf, IPV6)) != "AAAA" {
+		t.Error("IPv6 copy of a dual-stack configuration should update AAAA records")
+	}
+}
+
+func TestCheckSettingsPerDomain(t *testing.T) {
+	settings := &Settings{
+		Domains: []Domain{
+			{DomainName: "example.com", Provider: "Cloudflare", Email: "user@example.com", Password: "key"},
+			{DomainName: "www.duckdns.org", Provider: "DuckDNS", LoginToken: "token"},
+		},
+	}
+	if err := CheckSettings(settings); err != nil {
+		t.Error("setting with per-domain providers, should be passed:", err)
+	}
+
+	settings.Domains = append(settings.Domains, Domain{DomainName: "example.org", Provider: "AliDNS"})
+	if err := CheckSettings(settings); err == nil {
+		t.Error("domain without credentials, should be failed")
+	}
 }